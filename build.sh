@@ -10,3 +10,4 @@ set -e
 set -x
 
 GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/drone-runner-kube
+GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/drone-kube-shim ./cmd/drone-kube-shim
@@ -0,0 +1,49 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Command drone-kube-shim is the step entrypoint used by the
+// shim execution strategy. It is copied to the pipeline pod by
+// an init container, and blocks the step container until the
+// runner starts the step.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/internal/shim"
+)
+
+func main() {
+	var (
+		install  = flag.String("install", "", "copy the shim to the path and exit")
+		signal   = flag.String("signal", "", "path of the signal file")
+		exit     = flag.String("exit", "", "path of the exit code file")
+		interval = flag.Duration("interval", 250*time.Millisecond, "signal file polling interval")
+		grace    = flag.Duration("grace", 0, "duration the command is given to exit before it is killed")
+	)
+	flag.Parse()
+
+	if *install != "" {
+		if err := shim.Install(*install); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	os.Exit(shim.Run(context.Background(), shim.Config{
+		Signal:   *signal,
+		Exit:     *exit,
+		Interval: *interval,
+		Grace:    *grace,
+		Args:     flag.Args(),
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+	}))
+}
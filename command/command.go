@@ -25,6 +25,7 @@ func Command() {
 	app := kingpin.New("drone", "drone kubernetes runner")
 	registerCompile(app)
 	registerExec(app)
+	registerGC(app)
 	daemon.Register(app)
 
 	kingpin.Version(version)
@@ -0,0 +1,49 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// helper function registers the named clusters with the
+// engine, one cluster per kubeconfig context. If no contexts
+// are configured, all contexts in the kubeconfig file are
+// registered.
+func addClusters(kube *engine.Kubernetes, config Config) error {
+	path := config.Clusters.Kubeconfig
+	if path == "" {
+		return nil
+	}
+
+	contexts := config.Clusters.Contexts
+	if len(contexts) == 0 {
+		kubeconfig, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			return err
+		}
+		for name := range kubeconfig.Contexts {
+			contexts = append(contexts, name)
+		}
+	}
+
+	for _, name := range contexts {
+		cluster, err := engine.NewFromContext(path, name, engine.ClientConfig{
+			QPS:   config.Engine.ClientQPS,
+			Burst: config.Engine.ClientBurst,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot load cluster %s: %w", name, err)
+		}
+		cluster.PendingTimeout = config.Engine.PendingTimeout
+		cluster.UsageInterval = config.Engine.UsageInterval
+		kube.AddCluster(name, cluster)
+	}
+	return nil
+}
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
+	"github.com/ozonep/drone-runner-kube/engine"
 	"github.com/ozonep/drone-runner-kube/engine/policy"
 
 	"github.com/buildkite/yaml"
@@ -48,6 +50,7 @@ type Config struct {
 
 	Runner struct {
 		Name       string            `envconfig:"DRONE_RUNNER_NAME"`
+		Instance   string            `envconfig:"DRONE_RUNNER_INSTANCE"`
 		Capacity   int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"100"`
 		Procs      int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
 		Environ    map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
@@ -69,6 +72,19 @@ type Config struct {
 		LimitMemory   BytesSize `envconfig:"DRONE_RESOURCE_LIMIT_MEMORY"`
 		RequestCPU    int64     `envconfig:"DRONE_RESOURCE_REQUEST_CPU"`
 		RequestMemory BytesSize `envconfig:"DRONE_RESOURCE_REQUEST_MEMORY"`
+		RequestPeak   bool      `envconfig:"DRONE_RESOURCE_REQUEST_PEAK"`
+	}
+
+	Workspace struct {
+		StorageClass string    `envconfig:"DRONE_WORKSPACE_STORAGE_CLASS"`
+		ClaimSize    BytesSize `envconfig:"DRONE_WORKSPACE_CLAIM_SIZE"`
+		SizeLimit    BytesSize `envconfig:"DRONE_WORKSPACE_SIZE_LIMIT"`
+	}
+
+	Cache struct {
+		HostPath string    `envconfig:"DRONE_CACHE_HOST_PATH"`
+		Claim    string    `envconfig:"DRONE_CACHE_CLAIM"`
+		MaxSize  BytesSize `envconfig:"DRONE_CACHE_MAX_SIZE"`
 	}
 
 	Policy struct {
@@ -98,9 +114,54 @@ type Config struct {
 		Config string `envconfig:"DRONE_DOCKER_CONFIG"`
 	}
 
+	Engine struct {
+		PendingTimeout time.Duration `envconfig:"DRONE_ENGINE_PENDING_TIMEOUT" default:"10m"`
+		ClientQPS      float32       `envconfig:"DRONE_ENGINE_CLIENT_QPS"`
+		ClientBurst    int           `envconfig:"DRONE_ENGINE_CLIENT_BURST"`
+		UsageInterval  time.Duration `envconfig:"DRONE_ENGINE_USAGE_INTERVAL"`
+	}
+
+	Clusters struct {
+		Kubeconfig string   `envconfig:"DRONE_CLUSTERS_KUBECONFIG"`
+		Contexts   []string `envconfig:"DRONE_CLUSTERS_CONTEXTS"`
+	}
+
+	Reaper struct {
+		Disabled bool          `envconfig:"DRONE_REAPER_DISABLED"`
+		Interval time.Duration `envconfig:"DRONE_REAPER_INTERVAL" default:"1h"`
+	}
+
+	DebugHold struct {
+		Timeout time.Duration `envconfig:"DRONE_DEBUG_HOLD_TIMEOUT" default:"30m"`
+	}
+
+	Disruption struct {
+		Budget  bool `envconfig:"DRONE_DISRUPTION_BUDGET"`
+		Retries int  `envconfig:"DRONE_DISRUPTION_RETRIES"`
+	}
+
+	Mesh struct {
+		Disabled    bool              `envconfig:"DRONE_MESH_DISABLED"`
+		Annotations map[string]string `envconfig:"DRONE_MESH_DISABLED_ANNOTATIONS" default:"sidecar.istio.io/inject:false,linkerd.io/inject:disabled"`
+		Proxy       string            `envconfig:"DRONE_MESH_PROXY"`
+		QuitPort    int               `envconfig:"DRONE_MESH_PROXY_QUIT_PORT" default:"15020"`
+		QuitPath    string            `envconfig:"DRONE_MESH_PROXY_QUIT_PATH" default:"/quitquitquit"`
+	}
+
+	Cancel struct {
+		GracePeriod    time.Duration `envconfig:"DRONE_CANCEL_GRACE_PERIOD"`
+		CleanupTimeout time.Duration `envconfig:"DRONE_CANCEL_CLEANUP_TIMEOUT" default:"10m"`
+	}
+
+	Recovery struct {
+		Disabled bool `envconfig:"DRONE_RECOVERY_DISABLED"`
+	}
+
 	Images struct {
 		Clone       string `envconfig:"DRONE_IMAGE_CLONE"`
 		Placeholder string `envconfig:"DRONE_IMAGE_PLACEHOLDER"`
+		Cache       string `envconfig:"DRONE_IMAGE_CACHE"`
+		Shim        string `envconfig:"DRONE_IMAGE_SHIM"`
 	}
 
 	ServiceAccount struct {
@@ -152,9 +213,26 @@ func fromEnviron() (Config, error) {
 	if config.Runner.Name == "" {
 		config.Runner.Name, _ = os.Hostname()
 	}
+	// the instance identifies the runner process across
+	// restarts, when multiple runners share the same name.
+	if config.Runner.Instance == "" {
+		config.Runner.Instance, _ = os.Hostname()
+	}
+	// the cleanup steps run after the pipeline times out, and
+	// must complete before the pod active deadline, which is
+	// the pipeline timeout plus the deadline grace period.
+	if config.Cancel.CleanupTimeout > engine.DeadlineGrace {
+		return config, fmt.Errorf(
+			"the cleanup timeout cannot exceed %s", engine.DeadlineGrace)
+	}
 	if config.Dashboard.Password == "" {
 		config.Dashboard.Disabled = true
 	}
+	// the opt out annotations are only added to the pipeline
+	// pods when mesh injection is disabled.
+	if !config.Mesh.Disabled {
+		config.Mesh.Annotations = nil
+	}
 	config.Client.Address = fmt.Sprintf(
 		"%s://%s",
 		config.Client.Proto,
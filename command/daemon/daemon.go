@@ -80,17 +80,39 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	engine, err := engine.NewInCluster()
+	engine, err := engine.NewInCluster(engine.ClientConfig{
+		QPS:   config.Engine.ClientQPS,
+		Burst: config.Engine.ClientBurst,
+	})
 	if err != nil {
 		logrus.WithError(err).
 			Fatalln("cannot load the kubernetes engine")
 	}
+	engine.PendingTimeout = config.Engine.PendingTimeout
+	engine.UsageInterval = config.Engine.UsageInterval
+
+	if err := addClusters(engine, config); err != nil {
+		logrus.WithError(err).
+			Fatalln("cannot load the kubernetes clusters")
+	}
+	if names := engine.Clusters(); len(names) != 0 {
+		logrus.WithField("clusters", names).
+			Infoln("loaded the kubernetes clusters")
+	}
 
 	remote := remote.New(cli)
 	tracer := history.New(remote)
 	hook := loghistory.New()
 	logrus.AddHook(hook)
 
+	execer := runtime.NewExecer(
+		tracer,
+		remote,
+		engine,
+		config.Runner.Procs,
+	)
+	execer.CleanupTimeout = config.Cancel.CleanupTimeout
+
 	runner := &runtime.Runner{
 		Client:   cli,
 		Machine:  config.Runner.Name,
@@ -98,22 +120,35 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		Reporter: tracer,
 		Lookup:   resource.Lookup,
 		Lint:     linter.New(config.Namespace.Rules).Lint,
+		Retries:  config.Disruption.Retries,
 		Match: match.Func(
 			config.Limit.Repos,
 			config.Limit.Events,
 			config.Limit.Trusted,
 		),
 		Compiler: &compiler.Compiler{
-			Cloner:         config.Images.Clone,
-			Placeholder:    config.Images.Placeholder,
-			Volumes:        config.Runner.Volumes,
-			Namespace:      config.Namespace.Default,
-			Labels:         config.Labels.Default,
-			Annotations:    config.Annotations.Default,
-			ServiceAccount: config.ServiceAccount.Default,
-			NodeSelector:   config.NodeSelector.Default,
-			Privileged:     append(config.Runner.Privileged, compiler.Privileged...),
-			Policies:       config.Policy.Parsed,
+			Instance:         config.Runner.Instance,
+			Cloner:           config.Images.Clone,
+			Placeholder:      config.Images.Placeholder,
+			ShimImage:        config.Images.Shim,
+			Volumes:          config.Runner.Volumes,
+			Namespace:        config.Namespace.Default,
+			Labels:           config.Labels.Default,
+			Annotations:      config.Annotations.Default,
+			ServiceAccount:   config.ServiceAccount.Default,
+			NodeSelector:     config.NodeSelector.Default,
+			Privileged:       append(config.Runner.Privileged, compiler.Privileged...),
+			Policies:         config.Policy.Parsed,
+			PeakRequests:     config.Resources.RequestPeak,
+			DebugTimeout:     config.DebugHold.Timeout,
+			GracePeriod:      config.Cancel.GracePeriod,
+			DisruptionBudget: config.Disruption.Budget,
+			Mesh: compiler.Mesh{
+				Annotations: config.Mesh.Annotations,
+				Proxy:       config.Mesh.Proxy,
+				QuitPort:    config.Mesh.QuitPort,
+				QuitPath:    config.Mesh.QuitPath,
+			},
 			Registry: registry.Combine(
 				registry.File(
 					config.Docker.Config,
@@ -152,13 +187,19 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 					Memory: int64(config.Resources.RequestMemory),
 				},
 			},
+			Workspace: compiler.Workspace{
+				StorageClass: config.Workspace.StorageClass,
+				ClaimSize:    int64(config.Workspace.ClaimSize),
+				SizeLimit:    int64(config.Workspace.SizeLimit),
+			},
+			Cache: compiler.Cache{
+				HostPath:  config.Cache.HostPath,
+				ClaimName: config.Cache.Claim,
+				MaxSize:   int64(config.Cache.MaxSize),
+				Image:     config.Images.Cache,
+			},
 		},
-		Exec: runtime.NewExecer(
-			tracer,
-			remote,
-			engine,
-			config.Runner.Procs,
-		).Exec,
+		Exec: execer.Exec,
 	}
 
 	poller := &poller.Poller{
@@ -213,6 +254,13 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		}
 	}
 
+	// reconcile the stages that were in-flight when the
+	// runner was last stopped, before polling for new
+	// stages.
+	if !config.Recovery.Disabled {
+		recoverStages(ctx, engine, cli, config.Runner.Name, config.Runner.Instance)
+	}
+
 	g.Go(func() error {
 		logrus.WithField("capacity", config.Runner.Capacity).
 			WithField("endpoint", config.Client.Address).
@@ -224,6 +272,28 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		return nil
 	})
 
+	if !config.Reaper.Disabled {
+		g.Go(func() error {
+			logrus.WithField("interval", config.Reaper.Interval).
+				Infoln("starting the reaper")
+
+			reaper := time.NewTicker(config.Reaper.Interval)
+			defer reaper.Stop()
+			for {
+				err := engine.Reap(ctx, config.Runner.Name)
+				if err != nil {
+					logrus.WithError(err).
+						Errorln("cannot reap expired resources")
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-reaper.C:
+				}
+			}
+		})
+	}
+
 	err = g.Wait()
 	if err != nil {
 		logrus.WithError(err).
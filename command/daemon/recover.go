@@ -0,0 +1,93 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+	"github.com/ozonep/drone-runner-kube/pkg/client"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
+	"github.com/ozonep/drone/pkg/drone"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errRecovered is the error reported for stages that were
+// running when the runner process was restarted.
+var errRecovered = errors.New("the runner was restarted while the stage was running")
+
+// helper function reconciles the pipeline pods created by a
+// previous runner process with the same name and instance.
+// The in-flight stages are marked as errored on the server,
+// and the pods are deleted. The pods of other runner
+// instances that share the runner name are never deleted,
+// since their stages may still be running. Reattaching to
+// the running pods is not supported.
+func recoverStages(ctx context.Context, kube *engine.Kubernetes, cli client.Client, machine, instance string) {
+	orphans, err := kube.Orphans(ctx, machine, instance)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("cannot list orphaned pipeline pods")
+		return
+	}
+
+	for _, orphan := range orphans {
+		log := logrus.WithField("cluster", orphan.Cluster).
+			WithField("namespace", orphan.Namespace).
+			WithField("pod", orphan.Name).
+			WithField("stage.id", orphan.StageID)
+
+		if orphan.StageID != 0 {
+			if err := recoverStage(ctx, cli, orphan.StageID); err != nil {
+				log.WithError(err).
+					Errorln("cannot update orphaned stage")
+			} else {
+				log.Infoln("updated orphaned stage to error")
+			}
+		}
+
+		if err := kube.DeleteOrphan(ctx, orphan); err != nil {
+			log.WithError(err).
+				Errorln("cannot delete orphaned pipeline pod")
+		} else {
+			log.Infoln("deleted orphaned pipeline pod")
+		}
+	}
+}
+
+// helper function marks the stage, and its running steps,
+// as errored on the server.
+func recoverStage(ctx context.Context, cli client.Client, id int64) error {
+	data, err := cli.Detail(ctx, &drone.Stage{ID: id})
+	if err != nil {
+		return err
+	}
+
+	// stages that are already complete, for example stages
+	// that were cancelled or timed out by the server, do not
+	// need to be updated.
+	switch data.Stage.Status {
+	case drone.StatusPending, drone.StatusRunning:
+	default:
+		return nil
+	}
+
+	state := &pipeline.State{
+		Build:  data.Build,
+		Stage:  data.Stage,
+		Repo:   data.Repo,
+		System: data.System,
+	}
+	for _, step := range data.Stage.Steps {
+		if step.Status == drone.StatusRunning {
+			state.Fail(step.Name, errRecovered)
+		}
+	}
+	state.FailAll(errRecovered)
+	state.FinishAll()
+	return cli.Update(ctx, data.Stage)
+}
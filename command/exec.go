@@ -55,6 +55,7 @@ type execCommand struct {
 	Clone      bool
 	Pretty     bool
 	Procs      int64
+	Pending    time.Duration
 	Debug      bool
 	Trace      bool
 	Dump       bool
@@ -238,10 +239,11 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 	)
 
 	// change to out-of-cluster for local testing
-	engine, err := engine.NewFromConfig(kubeconfig)
+	engine, err := engine.NewFromConfig(kubeconfig, engine.ClientConfig{})
 	if err != nil {
 		return err
 	}
+	engine.PendingTimeout = c.Pending
 
 	err = runtime.NewExecer(
 		pipeline.NopReporter(),
@@ -317,6 +319,10 @@ func registerExec(app *kingpin.Application) {
 		Default("default").
 		StringVar(&c.Namespace)
 
+	cmd.Flag("pending-timeout", "maximum time a step can remain pending").
+		Default("10m").
+		DurationVar(&c.Pending)
+
 	cmd.Flag("debug", "enable debug logging").
 		BoolVar(&c.Debug)
 
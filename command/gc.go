@@ -0,0 +1,61 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"github.com/ozonep/drone-runner-kube/engine"
+	"github.com/ozonep/drone-runner-kube/pkg/logger"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type gcCommand struct {
+	Config string
+	Runner string
+	Debug  bool
+}
+
+func (c *gcCommand) run(*kingpin.ParseContext) error {
+	logrus.SetLevel(logrus.InfoLevel)
+	if c.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	logger.Default = logger.Logrus(
+		logrus.NewEntry(
+			logrus.StandardLogger(),
+		),
+	)
+
+	var (
+		kube *engine.Kubernetes
+		err  error
+	)
+	if c.Config != "" {
+		kube, err = engine.NewFromConfig(c.Config, engine.ClientConfig{})
+	} else {
+		kube, err = engine.New(engine.ClientConfig{})
+	}
+	if err != nil {
+		return err
+	}
+	return kube.Reap(nocontext, c.Runner)
+}
+
+func registerGC(app *kingpin.Application) {
+	c := new(gcCommand)
+
+	cmd := app.Command("gc", "deletes expired pipeline resources").
+		Action(c.run)
+
+	cmd.Flag("kubeconfig", "path to the kubernetes config file").
+		StringVar(&c.Config)
+
+	cmd.Flag("runner", "only delete the resources of the named runner").
+		StringVar(&c.Runner)
+
+	cmd.Flag("debug", "enable debug logging").
+		BoolVar(&c.Debug)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// helper function returns the pod disruption budget that
+// prevents the pipeline pods from being evicted, for example,
+// by a node drain, while the pipeline is running. A nil value
+// is returned if the disruption budget is not configured.
+func toPodDisruptionBudget(spec *Spec) *policyv1beta1.PodDisruptionBudget {
+	if !spec.DisruptionBudget {
+		return nil
+	}
+
+	// the budget selects the pipeline pod, and the step and
+	// service pods that run in separate pods.
+	names := []string{spec.PodSpec.Name}
+	for _, step := range spec.Steps {
+		if isSeparatePod(spec, step) {
+			names = append(names, step.ID)
+		}
+	}
+
+	maxUnavailable := intstr.FromInt(0)
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        spec.PodSpec.Name,
+			Namespace:   spec.PodSpec.Namespace,
+			Labels:      spec.PodSpec.Labels,
+			Annotations: spec.PodSpec.Annotations,
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "io.drone.name",
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   names,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/internal/shim"
+	"github.com/ozonep/drone-runner-kube/pkg/logger"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// default duration the steps are given to exit after the
+// termination signal, matching the kubernetes default.
+const defaultGracePeriod = 30 * time.Second
+
+// helper function returns the duration the steps are given
+// to exit after the termination signal, before they are
+// killed.
+func toGracePeriod(spec *Spec) time.Duration {
+	if spec.GracePeriod > 0 {
+		return spec.GracePeriod
+	}
+	return defaultGracePeriod
+}
+
+// helper function returns the pod termination grace period,
+// in seconds, or nil if the cluster default is used.
+func toGracePeriodSeconds(spec *Spec) *int64 {
+	if spec.GracePeriod <= 0 {
+		return nil
+	}
+	return int64ptr(int64(spec.GracePeriod.Seconds()))
+}
+
+// helper function stops the step if the step is cancelled,
+// for example, because the pipeline is cancelled, or another
+// step failed with the fail fast error policy. The step is
+// sent the termination signal, and is killed once the grace
+// period expires. Detached steps are not stopped, so that
+// they remain available to the steps that run on
+// cancellation, and are stopped when the pod is deleted.
+func (k *Kubernetes) kill(ctx context.Context, spec *Spec, step *Step) {
+	if ctx.Err() == nil || step.Init || step.Detach {
+		return
+	}
+
+	// the step is stopped even though the context is
+	// cancelled.
+	var err error
+	switch {
+	case isSeparatePod(spec, step):
+		err = retryTransient(context.Background(), func() error {
+			return k.client.CoreV1().Pods(spec.PodSpec.Namespace).Delete(step.ID, &metav1.DeleteOptions{
+				GracePeriodSeconds: toGracePeriodSeconds(spec),
+			})
+		})
+	case isShimStep(spec, step):
+		err = k.signal(context.Background(), spec, step, shim.SignalKill)
+	case step.Placeholder != "":
+		err = k.stop(spec, step)
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("step.name", step.Name).
+			Warnln("cannot stop the step")
+	}
+}
+
+// helper function stops the step as soon as the context is
+// cancelled, while the step is still running. The returned
+// function is called once the step is complete, and waits
+// until the step is stopped, if it was cancelled.
+func (k *Kubernetes) killOnCancel(ctx context.Context, spec *Spec, step *Step) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			k.kill(ctx, spec, step)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// helper function stops the step by replacing the step
+// image with the placeholder image. The kubelet terminates
+// the step container, honoring the pod termination grace
+// period, and restarts the container with the placeholder.
+func (k *Kubernetes) stop(spec *Spec, step *Step) error {
+	return retry.RetryOnConflict(backoff, func() error {
+		spec.podUpdateMutex.Lock()
+		defer spec.podUpdateMutex.Unlock()
+		pod, err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Get(spec.PodSpec.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		for i, container := range pod.Spec.Containers {
+			if container.Name == step.ID {
+				pod.Spec.Containers[i].Image = step.Placeholder
+			}
+		}
+
+		_, err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Update(pod)
+		return err
+	})
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKill(t *testing.T) {
+	build := &Step{ID: "drone-build", Name: "build", Image: "golang:1", Placeholder: "drone/placeholder:1"}
+	test := &Step{ID: "drone-test", Name: "test", Image: "golang:1", Placeholder: "drone/placeholder:1", Entrypoint: []string{"/bin/sh", "-c"}}
+	redis := &Step{ID: "drone-redis", Name: "redis", Image: "redis", Placeholder: "drone/placeholder:1", Detach: true}
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-pod",
+			Namespace: "default",
+		},
+		Shim:  &Shim{Image: "drone/drone-runner-kube"},
+		Steps: []*Step{build, test, redis},
+	}
+
+	pod := toPod(spec)
+	pod.Spec.Containers[0].Image = build.Image
+	pod.Spec.Containers[2].Image = redis.Image
+	client := fake.NewSimpleClientset(pod)
+	engine := &Kubernetes{client: client}
+
+	// the steps are not stopped unless the context is
+	// cancelled.
+	engine.kill(context.Background(), spec, build)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, step := range spec.Steps {
+		engine.kill(ctx, spec, step)
+	}
+
+	got, err := client.CoreV1().Pods("default").Get("drone-pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the step is stopped by restoring the placeholder image.
+	if got, want := got.Spec.Containers[0].Image, build.Placeholder; got != want {
+		t.Errorf("Want placeholder image %s, got %s", want, got)
+	}
+	// the shim step is sent the kill signal.
+	if got, want := got.Annotations[shimSignalKey(test)], "kill"; got != want {
+		t.Errorf("Want kill signal %q, got %q", want, got)
+	}
+	// the services keep running until the pod is deleted.
+	if got, want := got.Spec.Containers[2].Image, redis.Image; got != want {
+		t.Errorf("Want service image %s, got %s", want, got)
+	}
+}
+
+func TestKill_StepPod(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-pod",
+			Namespace: "default",
+		},
+		MultiPod:    true,
+		GracePeriod: 5 * time.Second,
+		Steps: []*Step{
+			{ID: "drone-build", Name: "build", Image: "golang:1"},
+		},
+	}
+	step := spec.Steps[0]
+
+	pod := toStepPod(spec, step)
+	if got, want := *pod.Spec.TerminationGracePeriodSeconds, int64(5); got != want {
+		t.Errorf("Want termination grace period %d, got %d", want, got)
+	}
+
+	client := fake.NewSimpleClientset(pod)
+	engine := &Kubernetes{client: client}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	engine.kill(ctx, spec, step)
+
+	_, err := client.CoreV1().Pods("default").Get("drone-build", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Want step pod deleted, got error %v", err)
+	}
+}
+
+// This test verifies a step is stopped as soon as the step
+// is cancelled, while the step is still running and the log
+// stream is still open.
+func TestRun_Cancel(t *testing.T) {
+	defer func(delay time.Duration) {
+		streamCloseDelay = delay
+	}(streamCloseDelay)
+	streamCloseDelay = time.Hour
+
+	step := &Step{ID: "drone-build", Name: "build", Image: "golang:1", Placeholder: "drone/placeholder:1"}
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-pod",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+		Steps: []*Step{step},
+	}
+
+	pod := toPod(spec)
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		{
+			Name:  step.ID,
+			Image: step.Image,
+			State: v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		},
+	}
+	client := fake.NewSimpleClientset(pod)
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	engine := &Kubernetes{
+		client: client,
+		pods:   newPodInformer(client),
+		logs:   &fakeLogs{reader: reader},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := engine.Run(ctx, spec, step, ioutil.Discard)
+		errc <- err
+	}()
+
+	// the step is running once the logs are streamed.
+	io.WriteString(writer, "hello world\n")
+	cancel()
+
+	for i := 0; ; i++ {
+		got, err := client.CoreV1().Pods("default").Get("drone-pod", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Spec.Containers[0].Image == step.Placeholder {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Want step stopped while the step is running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the log stream reaches EOF once the step exits.
+	writer.Close()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Want context canceled error, got %v", err)
+	}
+}
+
+func TestToGracePeriod(t *testing.T) {
+	spec := &Spec{}
+	if got, want := toGracePeriod(spec), defaultGracePeriod; got != want {
+		t.Errorf("Want default grace period %s, got %s", want, got)
+	}
+	if toGracePeriodSeconds(spec) != nil {
+		t.Errorf("Want cluster default termination grace period")
+	}
+	spec.GracePeriod = time.Minute
+	if got, want := toGracePeriod(spec), time.Minute; got != want {
+		t.Errorf("Want grace period %s, got %s", want, got)
+	}
+	if got, want := *toGracePeriodSeconds(spec), int64(60); got != want {
+		t.Errorf("Want termination grace period %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AddCluster registers a named cluster. A pipeline is routed
+// to the named cluster when the cluster is selected by policy
+// or by the pipeline configuration. Pipelines that do not
+// select a cluster run in the default cluster.
+func (k *Kubernetes) AddCluster(name string, cluster *Kubernetes) {
+	if k.clusters == nil {
+		k.clusters = map[string]*Kubernetes{}
+	}
+	k.clusters[name] = cluster
+}
+
+// Clusters returns the names of the registered clusters.
+func (k *Kubernetes) Clusters() []string {
+	var names []string
+	for name := range k.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// helper function returns the named cluster, or the default
+// cluster if the name is empty.
+func (k *Kubernetes) cluster(name string) (*Kubernetes, error) {
+	if name == "" {
+		return k, nil
+	}
+	cluster, ok := k.clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster: %s", name)
+	}
+	return cluster, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCluster(t *testing.T) {
+	prod := &Kubernetes{client: fake.NewSimpleClientset()}
+	sandbox := &Kubernetes{client: fake.NewSimpleClientset()}
+
+	engine := &Kubernetes{client: fake.NewSimpleClientset()}
+	engine.AddCluster("sandbox", sandbox)
+	engine.AddCluster("prod", prod)
+
+	if got, want := engine.Clusters(), []string{"prod", "sandbox"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Want clusters %v, got %v", want, got)
+	}
+
+	tests := []struct {
+		name string
+		want *Kubernetes
+	}{
+		{name: "", want: engine},
+		{name: "prod", want: prod},
+		{name: "sandbox", want: sandbox},
+	}
+	for _, test := range tests {
+		got, err := engine.cluster(test.name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Want cluster %q routed to the named engine", test.name)
+		}
+	}
+
+	if _, err := engine.cluster("staging"); err == nil {
+		t.Errorf("Want error for unknown cluster")
+	}
+}
+
+// This test verifies the pipeline resources are created in
+// the cluster selected by the pipeline.
+func TestClusterSetup(t *testing.T) {
+	sandbox := &Kubernetes{client: fake.NewSimpleClientset()}
+	engine := &Kubernetes{client: fake.NewSimpleClientset()}
+	engine.AddCluster("sandbox", sandbox)
+
+	spec := &Spec{
+		Cluster: "sandbox",
+		PodSpec: PodSpec{
+			Name:      "drone-test",
+			Namespace: "default",
+		},
+	}
+	if err := engine.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	defer spec.events.stop()
+
+	if _, err := sandbox.client.CoreV1().Pods("default").Get("drone-test", metav1.GetOptions{}); err != nil {
+		t.Errorf("Want pod created in the sandbox cluster, got %s", err)
+	}
+	if _, err := engine.client.CoreV1().Pods("default").Get("drone-test", metav1.GetOptions{}); err == nil {
+		t.Errorf("Want pod not created in the default cluster")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+	"github.com/ozonep/drone-runner-kube/engine/resource"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+	"github.com/ozonep/drone/pkg/drone"
+
+	"github.com/gosimple/slug"
+)
+
+// default image used to restore and evict the cache.
+const cacheImage = "busybox:1"
+
+// default cache key, if no key is configured in the yaml.
+const cacheKey = "${DRONE_BRANCH}"
+
+// path the cache root is mounted to when the cache is
+// restored.
+const cacheRoot = "/cache"
+
+// regular expression to match characters that are not valid
+// in cache keys.
+var reCacheKey = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cacheScript restores the cache key from the first fallback
+// key that exists, if the cache key does not exist, and then
+// evicts the least recently used cache keys until the total
+// cache size is below the maximum size.
+var cacheScript = fmt.Sprintf(`
+set -e
+dir=%s/$DRONE_CACHE_DIR
+mkdir -p "$dir"
+if [ ! -d "$dir/$DRONE_CACHE_KEY" ]; then
+	for key in $DRONE_CACHE_FALLBACK_KEYS; do
+		if [ -d "$dir/$key" ]; then
+			echo "restoring cache from key $key"
+			cp -a "$dir/$key" "$dir/.$HOSTNAME"
+			if [ -d "$dir/$DRONE_CACHE_KEY" ]; then
+				rm -rf "$dir/.$HOSTNAME"
+			else
+				mv "$dir/.$HOSTNAME" "$dir/$DRONE_CACHE_KEY"
+			fi
+			break
+		fi
+	done
+fi
+mkdir -p "$dir/$DRONE_CACHE_KEY"
+touch "$dir/$DRONE_CACHE_KEY"
+if [ "$DRONE_CACHE_MAX_SIZE" -gt 0 ]; then
+	total=$(du -sk %s | cut -f1)
+	for entry in $(ls -1dtr %s/*/* 2>/dev/null || true); do
+		if [ "$total" -le "$DRONE_CACHE_MAX_SIZE" ]; then
+			break
+		fi
+		if [ "$entry" = "$dir/$DRONE_CACHE_KEY" ]; then
+			continue
+		fi
+		size=$(du -sk "$entry" | cut -f1)
+		echo "evicting cache $entry"
+		rm -rf "$entry"
+		total=$((total - size))
+	done
+fi
+`, cacheRoot, cacheRoot, cacheRoot)
+
+// helper function mounts the cache paths to the pipeline
+// steps, and returns the step that restores the cache. The
+// cache is stored in a directory per repository, and a
+// sub-directory per cache key, so that repositories cannot
+// access the cache of other repositories. A nil step is
+// returned if the cache is not configured.
+func (c *Compiler) createCache(spec *engine.Spec, steps []*engine.Step, pipeline *resource.Pipeline, repo *drone.Repo, envs map[string]string) *engine.Step {
+	if pipeline.Cache == nil || len(pipeline.Cache.Paths) == 0 {
+		return nil
+	}
+	if pipeline.Platform.OS == "windows" {
+		return nil
+	}
+
+	id := random()
+	volume := &engine.Volume{}
+	switch {
+	case c.Cache.ClaimName != "":
+		volume.Claim = &engine.VolumeClaim{
+			ID:        id,
+			Name:      id,
+			ClaimName: c.Cache.ClaimName,
+		}
+	case c.Cache.HostPath != "":
+		volume.HostPath = &engine.VolumeHostPath{
+			ID:   id,
+			Name: id,
+			Path: c.Cache.HostPath,
+		}
+	default:
+		return nil
+	}
+	spec.Volumes = append(spec.Volumes, volume)
+
+	dir := fmt.Sprintf("%d-%s", repo.ID, slug.Make(repo.Slug))
+	key := expandCacheKey(pipeline.Cache.Key, envs)
+	if key == "" {
+		key = expandCacheKey(cacheKey, envs)
+	}
+	var fallbacks []string
+	for _, s := range pipeline.Cache.FallbackKeys {
+		if s := expandCacheKey(s, envs); s != "" && s != key {
+			fallbacks = append(fallbacks, s)
+		}
+	}
+
+	for _, step := range steps {
+		for _, p := range pipeline.Cache.Paths {
+			step.Volumes = append(step.Volumes, &engine.VolumeMount{
+				Name:    id,
+				Path:    p,
+				SubPath: path.Join(dir, key, cachePathName(p)),
+			})
+		}
+	}
+
+	image := c.Cache.Image
+	if image == "" {
+		image = cacheImage
+	}
+	return &engine.Step{
+		ID:         random(),
+		Name:       "restore-cache",
+		Image:      image,
+		Init:       true,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Command:    []string{cacheScript},
+		Envs: map[string]string{
+			"DRONE_CACHE_DIR":           dir,
+			"DRONE_CACHE_KEY":           key,
+			"DRONE_CACHE_FALLBACK_KEYS": strings.Join(fallbacks, " "),
+			"DRONE_CACHE_MAX_SIZE":      fmt.Sprint(c.Cache.MaxSize >> 10),
+		},
+		Volumes: []*engine.VolumeMount{
+			{
+				Name: id,
+				Path: cacheRoot,
+			},
+		},
+		RunPolicy: runtime.RunOnSuccess,
+		ErrPolicy: runtime.ErrFail,
+	}
+}
+
+// helper function expands the environment variables in the
+// cache key, and replaces characters that are not valid in
+// directory names.
+func expandCacheKey(key string, envs map[string]string) string {
+	key = os.Expand(key, func(name string) string {
+		return envs[name]
+	})
+	key = reCacheKey.ReplaceAllString(key, "_")
+	switch key {
+	case ".", "..":
+		return ""
+	}
+	return key
+}
+
+// helper function returns the name of the cache directory
+// for the path, relative to the cache key directory.
+func cachePathName(p string) string {
+	name := strings.Trim(path.Clean(p), "/")
+	return reCacheKey.ReplaceAllString(name, "_")
+}
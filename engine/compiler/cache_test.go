@@ -0,0 +1,114 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+	"github.com/ozonep/drone-runner-kube/engine/resource"
+	"github.com/ozonep/drone/pkg/drone"
+
+	"github.com/dchest/uniuri"
+)
+
+func TestCreateCache(t *testing.T) {
+	random = notRandom
+	defer func() {
+		random = uniuri.New
+	}()
+
+	c := &Compiler{
+		Cache: Cache{
+			HostPath: "/var/lib/drone/cache",
+			MaxSize:  1 << 30,
+		},
+	}
+	pipeline := &resource.Pipeline{
+		Cache: &resource.Cache{
+			Key:          "${DRONE_BRANCH}-${GO_SUM}",
+			FallbackKeys: []string{"${DRONE_BRANCH}", "master"},
+			Paths:        []string{"/go/pkg/mod"},
+		},
+	}
+	repo := &drone.Repo{ID: 42, Slug: "octocat/hello-world"}
+	envs := map[string]string{
+		"DRONE_BRANCH": "feature/cache",
+		"GO_SUM":       "d41d8cd9",
+	}
+	spec := &engine.Spec{}
+	step := &engine.Step{Name: "build"}
+
+	restore := c.createCache(spec, []*engine.Step{step}, pipeline, repo, envs)
+	if restore == nil {
+		t.Fatalf("Want cache restore step")
+	}
+	if !restore.Init {
+		t.Errorf("Want cache restored by an init step")
+	}
+	if got, want := restore.Envs["DRONE_CACHE_DIR"], "42-octocat-hello-world"; got != want {
+		t.Errorf("Want cache dir %q, got %q", want, got)
+	}
+	if got, want := restore.Envs["DRONE_CACHE_KEY"], "feature_cache-d41d8cd9"; got != want {
+		t.Errorf("Want cache key %q, got %q", want, got)
+	}
+	if got, want := restore.Envs["DRONE_CACHE_FALLBACK_KEYS"], "feature_cache master"; got != want {
+		t.Errorf("Want fallback keys %q, got %q", want, got)
+	}
+	if got, want := restore.Envs["DRONE_CACHE_MAX_SIZE"], "1048576"; got != want {
+		t.Errorf("Want max size %q, got %q", want, got)
+	}
+
+	if len(spec.Volumes) != 1 || spec.Volumes[0].HostPath == nil {
+		t.Fatalf("Want cache host volume")
+	}
+	if len(step.Volumes) != 1 {
+		t.Fatalf("Want cache mounted to the step")
+	}
+	if got, want := step.Volumes[0].SubPath, "42-octocat-hello-world/feature_cache-d41d8cd9/go_pkg_mod"; got != want {
+		t.Errorf("Want sub path %q, got %q", want, got)
+	}
+	if got, want := step.Volumes[0].Path, "/go/pkg/mod"; got != want {
+		t.Errorf("Want mount path %q, got %q", want, got)
+	}
+}
+
+// This test verifies the cache is disabled if the runner
+// does not configure the cache storage.
+func TestCreateCache_Disabled(t *testing.T) {
+	c := &Compiler{}
+	pipeline := &resource.Pipeline{
+		Cache: &resource.Cache{
+			Paths: []string{"/go/pkg/mod"},
+		},
+	}
+	spec := &engine.Spec{}
+	restore := c.createCache(spec, nil, pipeline, &drone.Repo{}, nil)
+	if restore != nil {
+		t.Errorf("Want cache disabled")
+	}
+	if len(spec.Volumes) != 0 {
+		t.Errorf("Want no cache volume")
+	}
+}
+
+func TestExpandCacheKey(t *testing.T) {
+	envs := map[string]string{"DRONE_BRANCH": "main"}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"${DRONE_BRANCH}", "main"},
+		{"deps-${DRONE_BRANCH}", "deps-main"},
+		{"../../etc", ".._.._etc"},
+		{"..", ""},
+		{"${UNKNOWN}", ""},
+	}
+	for _, test := range tests {
+		if got := expandCacheKey(test.key, envs); got != test.want {
+			t.Errorf("Want cache key %q, got %q", test.want, got)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/util/validation"
 
@@ -59,9 +60,71 @@ type (
 		Memory int64
 	}
 
+	// Cache describes the storage used to persist the
+	// pipeline cache between builds.
+	Cache struct {
+		// HostPath provides the host directory that stores
+		// the cache.
+		HostPath string
+
+		// ClaimName provides the persistentVolumeClaim that
+		// stores the cache. The claim must be shared by all
+		// pipeline pods.
+		ClaimName string
+
+		// MaxSize provides the maximum size of the cache.
+		// The least recently used cache keys are evicted
+		// when the maximum size is exceeded.
+		MaxSize int64
+
+		// Image provides the image used to restore and
+		// evict the cache.
+		Image string
+	}
+
+	// Workspace describes the default workspace volume.
+	Workspace struct {
+		// StorageClass provides the storage class of the
+		// workspace claim.
+		StorageClass string
+
+		// ClaimSize provides the size of the workspace claim.
+		// If non-zero, the workspace is backed by a claim
+		// that is created for each pipeline.
+		ClaimSize int64
+
+		// SizeLimit provides the size limit of the workspace
+		// temporary directory.
+		SizeLimit int64
+	}
+
+	// Mesh describes the service mesh proxy injected into
+	// the pipeline pods.
+	Mesh struct {
+		// Annotations provides a set of annotations that are
+		// added to the pipeline pods to opt out of injection.
+		Annotations map[string]string
+
+		// Proxy provides the name of the injected proxy
+		// container. If configured, the steps wait for the
+		// proxy to be ready before they start, and the proxy
+		// is stopped before the pods are deleted.
+		Proxy string
+
+		// QuitPort and QuitPath provide the proxy endpoint
+		// that stops the proxy.
+		QuitPort int
+		QuitPath string
+	}
+
 	// Compiler compiles the Yaml configuration file to an
 	// intermediate representation optimized for simple execution.
 	Compiler struct {
+		// Instance provides the identifier of the runner
+		// instance, used to distinguish the pipeline pods of
+		// runners that share the same name.
+		Instance string
+
 		// Environ provides a set of environment variables that
 		// should be added to each pipeline step by default.
 		Environ provider.Provider
@@ -94,6 +157,15 @@ type (
 		// default to all pipeline containers if none exist.
 		Resources Resources
 
+		// Workspace defines the workspace volume that is used
+		// by default if no workspace volume is configured.
+		Workspace Workspace
+
+		// Cache defines the storage used to persist the cache
+		// between builds. The cache is disabled if no storage
+		// is configured.
+		Cache Cache
+
 		// Cloner provides an option to override the default clone
 		// image used to clone the repository when the pipeline
 		// initializes.
@@ -104,6 +176,12 @@ type (
 		// for execution.
 		Placeholder string
 
+		// ShimImage provides the image the entrypoint shim is
+		// copied from. If configured, the steps are created with
+		// the step image, and blocked by the shim until they are
+		// started, instead of using the placeholder image.
+		ShimImage string
+
 		// Namespace provides the default kubernetes namespace
 		// when no namespace is provided.
 		Namespace string
@@ -118,6 +196,29 @@ type (
 		// Policy provides a set of policies used to set defaults
 		// based on matching logic.
 		Policies []*policy.Policy
+
+		// PeakRequests configures the pod to request the peak
+		// resources of the steps that can run at the same time,
+		// instead of the sum of the resources of all steps.
+		PeakRequests bool
+
+		// DebugTimeout provides the duration the pod is held
+		// when a step fails and debug mode is enabled.
+		DebugTimeout time.Duration
+
+		// GracePeriod provides the duration the steps are given
+		// to exit after the termination signal when the pipeline
+		// is cancelled, before they are killed.
+		GracePeriod time.Duration
+
+		// DisruptionBudget configures a pod disruption budget
+		// that prevents the pipeline pods from being evicted
+		// while the pipeline is running.
+		DisruptionBudget bool
+
+		// Mesh configures the handling of the service mesh
+		// proxy injected into the pipeline pods.
+		Mesh Mesh
 	}
 )
 
@@ -139,6 +240,7 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	// create annotations
 	podAnnotations := labels.Combine(
 		c.Annotations,
+		c.Mesh.Annotations,
 		labels.FromRepo(args.Repo),
 		labels.FromBuild(args.Build),
 		labels.FromStage(args.Stage),
@@ -154,12 +256,7 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	}
 
 	// create the workspace volume
-	workVolume := &engine.Volume{
-		EmptyDir: &engine.VolumeEmptyDir{
-			ID:   random(),
-			Name: workMount.Name,
-		},
-	}
+	workVolume := createWorkspaceVolume(pipeline, c.Workspace, workMount.Name)
 
 	// create the statuses volume
 	statusMount := &engine.VolumeMount{
@@ -197,8 +294,28 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 			Variant: pipeline.Platform.Variant,
 			Version: pipeline.Platform.Version,
 		},
-		Secrets: map[string]*engine.Secret{},
-		Volumes: []*engine.Volume{workVolume, statusVolume},
+		Cluster:          pipeline.Cluster,
+		MultiPod:         pipeline.MultiPod,
+		ServicePods:      pipeline.ServicePods,
+		GracePeriod:      c.GracePeriod,
+		DisruptionBudget: c.DisruptionBudget,
+		Secrets:          map[string]*engine.Secret{},
+		Volumes:          []*engine.Volume{workVolume, statusVolume},
+	}
+
+	// the entrypoint shim is only supported on linux.
+	if c.ShimImage != "" && pipeline.Platform.OS != "windows" {
+		spec.Shim = &engine.Shim{
+			Image: c.ShimImage,
+		}
+	}
+
+	if c.Mesh.Proxy != "" {
+		spec.Mesh = &engine.Mesh{
+			Proxy:    c.Mesh.Proxy,
+			QuitPort: c.Mesh.QuitPort,
+			QuitPath: c.Mesh.QuitPath,
+		}
 	}
 
 	// set default namespace
@@ -285,10 +402,16 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	// create the workspace variables
 	envs["DRONE_WORKSPACE"] = workspace
 
+	// create the step output variables
+	envs["DRONE_OUTPUT"] = engine.OutputPath
+
 	// create volume reference variables
-	if workVolume.EmptyDir != nil {
+	switch {
+	case workVolume.EmptyDir != nil:
 		envs["DRONE_DOCKER_VOLUME_ID"] = workVolume.EmptyDir.ID
-	} else {
+	case workVolume.Claim != nil:
+		envs["DRONE_DOCKER_VOLUME_ID"] = workVolume.Claim.ID
+	case workVolume.HostPath != nil:
 		envs["DRONE_DOCKER_VOLUME_PATH"] = workVolume.HostPath.Path
 	}
 
@@ -317,6 +440,14 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	spec.PodSpec.Labels["io.drone.repo.name"] = slug.Make(args.Repo.Name)
 	spec.PodSpec.Labels["io.drone.build.number"] = fmt.Sprint(args.Build.Number)
 	spec.PodSpec.Labels["io.drone.build.event"] = slug.Make(args.Build.Event)
+	spec.PodSpec.Labels["io.drone.runner"] = slug.Make(args.Stage.Machine)
+	if c.Instance != "" {
+		spec.PodSpec.Labels["io.drone.runner.instance"] = slug.Make(c.Instance)
+	}
+
+	// set the stage identifier, used to reconcile in-flight
+	// stages if the runner is restarted.
+	spec.PodSpec.Annotations["io.drone.stage.id"] = fmt.Sprint(args.Stage.ID)
 
 	match := manifest.Match{
 		Action:   args.Build.Action,
@@ -356,7 +487,15 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		dst := createStep(pipeline, src)
 		dst.Detach = true
 		dst.Envs = environ.Combine(envs, dst.Envs)
-		dst.Volumes = append(dst.Volumes, workMount, statusMount)
+		// if the services run in separate pods, the workspace
+		// is only mounted if it can be shared between pods. It
+		// is shared in multi-pod mode, where the workspace is a
+		// ReadWriteMany claim, but the default workspace volume
+		// is an emptyDir that is local to the pipeline pod.
+		if !pipeline.ServicePods || pipeline.MultiPod {
+			dst.Volumes = append(dst.Volumes, workMount)
+		}
+		dst.Volumes = append(dst.Volumes, statusMount)
 		setupScript(src, dst, os)
 		setupWorkdir(src, dst, workspace)
 		spec.Steps = append(spec.Steps, dst)
@@ -372,7 +511,10 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 			dst.Placeholder = c.Placeholder
 		}
 
-		if len(validation.IsDNS1123Subdomain(src.Name)) == 0 {
+		// if the services run in separate pods, the service
+		// names are resolved to the Kubernetes service
+		// addresses by the engine.
+		if len(validation.IsDNS1123Subdomain(src.Name)) == 0 && !pipeline.ServicePods {
 			hostnames = append(hostnames, src.Name)
 		}
 
@@ -426,6 +568,48 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		removeCloneDeps(spec)
 	}
 
+	// the step outputs are exported once the dependency graph
+	// is known.
+	setupOutputs(spec, os)
+
+	// create init steps. init steps run in order, as pod init
+	// containers, before all other steps including the clone
+	// step. steps with unmet conditions are not created.
+	var inits []*engine.Step
+	for _, src := range pipeline.Init {
+		if !src.When.Match(match) {
+			continue
+		}
+		dst := createStep(pipeline, src)
+		dst.Init = true
+		dst.Detach = false
+		dst.Placeholder = ""
+		dst.RunPolicy = runtime.RunOnSuccess
+		dst.ErrPolicy = runtime.ErrFail
+		dst.Envs = environ.Combine(envs, dst.Envs)
+		dst.Volumes = append(dst.Volumes, workMount, statusMount)
+		setupScript(src, dst, os)
+		setupWorkdir(src, dst, workspace)
+		inits = append(inits, dst)
+
+		if c.isPrivileged(src) {
+			dst.Privileged = true
+		}
+	}
+	// the cache is mounted to all steps, and is restored
+	// before the init steps run.
+	var steps []*engine.Step
+	steps = append(steps, inits...)
+	steps = append(steps, spec.Steps...)
+	if restore := c.createCache(spec, steps, pipeline, args.Repo, envs); restore != nil {
+		inits = append([]*engine.Step{restore}, inits...)
+	}
+
+	if len(inits) > 0 {
+		configureInitDeps(spec, inits)
+		spec.Steps = append(inits, spec.Steps...)
+	}
+
 	for _, step := range spec.Steps {
 		for _, s := range step.Secrets {
 			// if the secret was already fetched and stored in the
@@ -541,11 +725,33 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		}
 	}
 
+	// the pipeline can be configured to hold the pod when a
+	// step fails, so the step can be debugged interactively.
+	if isDebug(pipeline, args.Repo, args.Build) {
+		placeholder := placeholderImage
+		if c.Placeholder != "" {
+			placeholder = c.Placeholder
+		}
+		spec.Debug = createDebug(spec, envs, workspace, placeholder, c.DebugTimeout)
+
+		// the pod is held after the step fails, and must not
+		// be reaped while the failed step is debugged.
+		extendExpiry(spec.PodSpec.Annotations, spec.Debug.Timeout)
+	}
+
 	// apply default policy
 	if m := policy.Match(match, c.Policies); m != nil {
 		m.Apply(spec)
 	}
 
+	// the resource requests are computed once the default
+	// resources and the policy are applied. In multi-pod mode
+	// the steps run in separate pods, and the requests of each
+	// pod are the requests of the step.
+	if c.PeakRequests && !spec.MultiPod {
+		configurePeakRequests(spec)
+	}
+
 	return spec
 }
 
@@ -10,7 +10,10 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"github.com/ozonep/drone-runner-kube/engine"
@@ -173,6 +176,268 @@ func TestCompile_Secrets(t *testing.T) {
 	}
 }
 
+// This test verifies that debug mode can be enabled with
+// a build parameter, and that the debug container mounts
+// the pipeline workspace.
+func TestCompile_Debug(t *testing.T) {
+	manifest, _ := manifest.ParseFile("testdata/serial.yml")
+
+	compiler := &Compiler{
+		Environ:      provider.Static(nil),
+		Registry:     registry.Static(nil),
+		Secret:       secret.Static(nil),
+		DebugTimeout: time.Hour,
+	}
+	args := runtime.CompilerArgs{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: manifest.Resources[0].(*resource.Pipeline),
+		Secret:   secret.Static(nil),
+	}
+
+	ir := compiler.Compile(nocontext, args).(*engine.Spec)
+	if ir.Debug != nil {
+		t.Errorf("Expect debug mode disabled")
+	}
+
+	// the build parameter is ignored for untrusted repositories.
+	args.Build.Params = map[string]string{"DRONE_DEBUG_HOLD": "true"}
+	ir = compiler.Compile(nocontext, args).(*engine.Spec)
+	if ir.Debug != nil {
+		t.Errorf("Expect debug mode disabled for untrusted repositories")
+	}
+
+	args.Repo.Trusted = true
+	ir = compiler.Compile(nocontext, args).(*engine.Spec)
+	if ir.Debug == nil {
+		t.Errorf("Expect debug mode enabled")
+		return
+	}
+	if got, want := ir.Debug.Timeout, time.Hour; got != want {
+		t.Errorf("Want debug timeout %s, got %s", want, got)
+	}
+	if got, want := ir.Debug.Step.Envs["DRONE_DEBUG_TIMEOUT"], "3600"; got != want {
+		t.Errorf("Want debug timeout variable %s, got %s", want, got)
+	}
+	if got, want := ir.Debug.Step.Volumes, ir.Steps[0].Volumes; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want debug container mounts %v, got %v", want, got)
+	}
+
+	// the resources expire after the pod is held.
+	args.Build.Params = nil
+	held := ir.PodSpec.Annotations["io.drone.expires"]
+	ir = compiler.Compile(nocontext, args).(*engine.Spec)
+	expires, _ := strconv.ParseInt(ir.PodSpec.Annotations["io.drone.expires"], 10, 64)
+	extended, _ := strconv.ParseInt(held, 10, 64)
+	if diff := extended - expires; diff < 3600 || diff > 3605 {
+		t.Errorf("Want expiration extended by the debug timeout, got %d seconds", diff)
+	}
+}
+
+// This test verifies that init steps run in order, before
+// all other steps including the clone step.
+func TestCompile_Init(t *testing.T) {
+	manifest, _ := manifest.ParseFile("testdata/init.yml")
+
+	compiler := &Compiler{
+		Environ:  provider.Static(nil),
+		Registry: registry.Static(nil),
+		Secret:   secret.Static(nil),
+	}
+	args := runtime.CompilerArgs{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: manifest.Resources[0].(*resource.Pipeline),
+		Secret:   secret.Static(nil),
+	}
+
+	ir := compiler.Compile(nocontext, args).(*engine.Spec)
+
+	var names []string
+	for _, step := range ir.Steps {
+		names = append(names, step.Name)
+	}
+	if got, want := names, []string{"permissions", "credentials", "clone", "build", "test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want steps %v, got %v", want, got)
+	}
+
+	deps := map[string][]string{
+		"permissions": nil,
+		"credentials": {"permissions"},
+		"clone":       {"credentials"},
+		"build":       {"clone"},
+		"test":        {"build"},
+	}
+	for _, step := range ir.Steps {
+		if got, want := step.DependsOn, deps[step.Name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Want step %s to depend on %v, got %v", step.Name, want, got)
+		}
+		if got, want := step.Init, step.Name == "permissions" || step.Name == "credentials"; got != want {
+			t.Errorf("Want step %s init %v, got %v", step.Name, want, got)
+		}
+	}
+}
+
+// This test verifies the cleanup steps that run when the
+// pipeline is cancelled, and the cancellation grace period.
+func TestCompile_OnCancel(t *testing.T) {
+	manifest, _ := manifest.ParseFile("testdata/on_cancel.yml")
+
+	compiler := &Compiler{
+		Environ:     provider.Static(nil),
+		Registry:    registry.Static(nil),
+		Secret:      secret.Static(nil),
+		GracePeriod: time.Minute,
+	}
+	args := runtime.CompilerArgs{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: manifest.Resources[0].(*resource.Pipeline),
+		Secret:   secret.Static(nil),
+	}
+
+	ir := compiler.Compile(nocontext, args).(*engine.Spec)
+	if got, want := ir.GracePeriod, time.Minute; got != want {
+		t.Errorf("Want grace period %s, got %s", want, got)
+	}
+	for _, step := range ir.Steps {
+		if got, want := step.OnCancel, step.Name == "teardown"; got != want {
+			t.Errorf("Want on cancel %v for step %s, got %v", want, step.Name, got)
+		}
+	}
+}
+
+// This test verifies the mesh opt out annotations are added
+// to the pipeline pod, and can be overridden by the pipeline,
+// and that the mesh proxy is configured.
+func TestCompile_Mesh(t *testing.T) {
+	manifest, _ := manifest.ParseFile("testdata/serial.yml")
+
+	compiler := &Compiler{
+		Environ:  provider.Static(nil),
+		Registry: registry.Static(nil),
+		Secret:   secret.Static(nil),
+		Mesh: Mesh{
+			Annotations: map[string]string{
+				"sidecar.istio.io/inject": "false",
+				"linkerd.io/inject":       "disabled",
+			},
+			Proxy:    "istio-proxy",
+			QuitPort: 15020,
+			QuitPath: "/quitquitquit",
+		},
+	}
+	pipeline := manifest.Resources[0].(*resource.Pipeline)
+	pipeline.Metadata.Annotations = map[string]string{
+		"linkerd.io/inject": "enabled",
+	}
+	args := runtime.CompilerArgs{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: pipeline,
+		Secret:   secret.Static(nil),
+	}
+
+	ir := compiler.Compile(nocontext, args).(*engine.Spec)
+	if got, want := ir.PodSpec.Annotations["sidecar.istio.io/inject"], "false"; got != want {
+		t.Errorf("Want istio injection annotation %q, got %q", want, got)
+	}
+	if got, want := ir.PodSpec.Annotations["linkerd.io/inject"], "enabled"; got != want {
+		t.Errorf("Want pipeline annotation %q to override the opt out, got %q", want, got)
+	}
+	want := &engine.Mesh{
+		Proxy:    "istio-proxy",
+		QuitPort: 15020,
+		QuitPath: "/quitquitquit",
+	}
+	if got := ir.Mesh; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want mesh %v, got %v", want, got)
+	}
+}
+
+// This test verifies the services run in separate pods are
+// not aliased to the loopback address, and do not mount the
+// workspace unless multi-pod mode is enabled. It also verifies
+// the service readiness checks.
+func TestCompile_ServicePods(t *testing.T) {
+	manifest, _ := manifest.ParseFile("testdata/service_pods.yml")
+
+	compiler := &Compiler{
+		Environ:  provider.Static(nil),
+		Registry: registry.Static(nil),
+		Secret:   secret.Static(nil),
+	}
+	args := runtime.CompilerArgs{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: manifest.Resources[0].(*resource.Pipeline),
+		Secret:   secret.Static(nil),
+	}
+
+	ir := compiler.Compile(nocontext, args).(*engine.Spec)
+	if !ir.ServicePods {
+		t.Errorf("Want services run in separate pods")
+	}
+	if len(ir.PodSpec.HostAliases) != 0 {
+		t.Errorf("Want no loopback host aliases, got %v", ir.PodSpec.HostAliases)
+	}
+	for _, step := range ir.Steps {
+		if !step.Detach {
+			continue
+		}
+		if got, want := step.Ports, []engine.Port{{Port: 5432}}; step.Name == "postgres" && !reflect.DeepEqual(got, want) {
+			t.Errorf("Want service ports %v, got %v", want, got)
+		}
+		if step.Name == "postgres" && (step.Ready == nil || step.Ready.TCP != 5432 || step.Ready.Timeout != readyTimeout) {
+			t.Errorf("Want service readiness check with default timeout, got %v", step.Ready)
+		}
+		for _, mount := range step.Volumes {
+			if mount.Name == "_workspace" {
+				t.Errorf("Want workspace not mounted to service %s", step.Name)
+			}
+		}
+	}
+
+	// in multi-pod mode the workspace is a shared claim, and
+	// is mounted to the service pods.
+	args.Pipeline.(*resource.Pipeline).MultiPod = true
+	ir = compiler.Compile(nocontext, args).(*engine.Spec)
+	for _, step := range ir.Steps {
+		if !step.Detach {
+			continue
+		}
+		var mounted bool
+		for _, mount := range step.Volumes {
+			if mount.Name == "_workspace" {
+				mounted = true
+			}
+		}
+		if !mounted {
+			t.Errorf("Want workspace mounted to service %s in multi-pod mode", step.Name)
+		}
+	}
+}
+
 // helper function parses and compiles the source file and then
 // compares to a golden json file.
 func testCompile(t *testing.T, source, golden string) *engine.Spec {
@@ -0,0 +1,92 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+	"github.com/ozonep/drone-runner-kube/engine/resource"
+	"github.com/ozonep/drone/pkg/drone"
+)
+
+// default duration the pod is held when a step fails in
+// debug mode.
+const defaultDebugTimeout = 30 * time.Minute
+
+// debugScript keeps the debug container running until the
+// debug timeout is exceeded, or the session is ended early
+// by creating the done file.
+var debugScript = fmt.Sprintf(
+	`i=0; while [ "$i" -lt "$DRONE_DEBUG_TIMEOUT" ] && [ ! -f %s ]; do sleep 1; i=$((i+1)); done`,
+	engine.DebugDoneFile,
+)
+
+// helper function returns true if debug mode is enabled for
+// the pipeline, either in the yaml or with a build parameter.
+// The yaml setting is rejected by the linter for untrusted
+// repositories, so the build parameter is ignored for them.
+func isDebug(pipeline *resource.Pipeline, repo *drone.Repo, build *drone.Build) bool {
+	if pipeline.Platform.OS == "windows" {
+		return false
+	}
+	if pipeline.Debug {
+		return true
+	}
+	return repo.Trusted && build.Params["DRONE_DEBUG_HOLD"] == "true"
+}
+
+// helper function creates the debug container. The container
+// has the pipeline environment and mounts the volumes of all
+// pipeline steps, so the workspace can be inspected after a
+// step fails.
+func createDebug(spec *engine.Spec, envs map[string]string, workspace, placeholder string, timeout time.Duration) *engine.Debug {
+	if timeout == 0 {
+		timeout = defaultDebugTimeout
+	}
+
+	step := &engine.Step{
+		ID:          random(),
+		Name:        "debug",
+		Placeholder: placeholder,
+		Entrypoint:  []string{"/bin/sh", "-c"},
+		Command:     []string{debugScript},
+		Envs:        map[string]string{},
+		WorkingDir:  workspace,
+	}
+	for k, v := range envs {
+		step.Envs[k] = v
+	}
+	step.Envs["DRONE_DEBUG_TIMEOUT"] = fmt.Sprint(int64(timeout.Seconds()))
+
+	mounted := map[string]bool{}
+	for _, s := range spec.Steps {
+		for _, v := range s.Volumes {
+			if mounted[v.Path] {
+				continue
+			}
+			mounted[v.Path] = true
+			step.Volumes = append(step.Volumes, v)
+		}
+	}
+
+	return &engine.Debug{
+		Step:    step,
+		Timeout: timeout,
+	}
+}
+
+// helper function extends the expiration time of the pipeline
+// resources, defined by the io.drone.expires annotation, so
+// the resources are not reaped while the pod is held.
+func extendExpiry(annotations map[string]string, d time.Duration) {
+	expires, err := strconv.ParseInt(annotations["io.drone.expires"], 10, 64)
+	if err != nil {
+		return
+	}
+	annotations["io.drone.expires"] = fmt.Sprint(expires + int64(d.Seconds()))
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"sort"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+)
+
+// minimum requests assigned to containers with limits, to
+// prevent Kubernetes from defaulting the requests to the
+// limits.
+const (
+	minRequestCPU    = 1       // 1 millicore
+	minRequestMemory = 1 << 20 // 1 MiB
+)
+
+// resourceType provides access to the request and the limit
+// of a single resource type.
+type resourceType struct {
+	minimum int64
+	get     func(*engine.Resources) (request *int64, limit int64)
+}
+
+var resourceTypes = []resourceType{
+	{
+		minimum: minRequestCPU,
+		get: func(r *engine.Resources) (*int64, int64) {
+			return &r.Requests.CPU, r.Limits.CPU
+		},
+	},
+	{
+		minimum: minRequestMemory,
+		get: func(r *engine.Resources) (*int64, int64) {
+			return &r.Requests.Memory, r.Limits.Memory
+		},
+	},
+}
+
+// helper function replaces the container requests with the
+// peak requests of the steps that can run at the same time.
+// Every step is a container in the pipeline pod, and the pod
+// requests are the sum of the container requests, so a serial
+// pipeline would otherwise request the resources of all steps,
+// even though only one step runs at a time. The peak requests
+// are placed on as few containers as possible, and the limits
+// of each container are unchanged.
+func configurePeakRequests(spec *engine.Spec) {
+	// init steps run before the pod containers start, and are
+	// not included in the sum of the container requests. The
+	// services are not included if they run in separate pods.
+	var steps []*engine.Step
+	for _, step := range spec.Steps {
+		if !step.Init && !(spec.ServicePods && step.Detach) {
+			steps = append(steps, step)
+		}
+	}
+
+	concurrent := concurrentSteps(steps)
+	for _, t := range resourceTypes {
+		total := int64(0)
+		for _, step := range steps {
+			total += effectiveRequest(step, t)
+		}
+		peak := peakRequest(steps, concurrent, t)
+		if peak < total {
+			placeRequest(steps, peak, t)
+		}
+	}
+}
+
+// helper function returns a matrix where the value at [i][j]
+// is true if steps i and j can run at the same time. Steps
+// can run at the same time unless one step depends, directly
+// or indirectly, on the other.
+func concurrentSteps(steps []*engine.Step) [][]bool {
+	index := map[string]int{}
+	for i, step := range steps {
+		index[step.Name] = i
+	}
+
+	// ancestors[i][j] is true if step i depends on step j.
+	ancestors := make([][]bool, len(steps))
+	var visit func(i int)
+	visit = func(i int) {
+		if ancestors[i] != nil {
+			return
+		}
+		ancestors[i] = make([]bool, len(steps))
+		for _, name := range steps[i].DependsOn {
+			j, ok := index[name]
+			if !ok || j == i {
+				continue
+			}
+			visit(j)
+			ancestors[i][j] = true
+			for k, ok := range ancestors[j] {
+				if ok {
+					ancestors[i][k] = true
+				}
+			}
+		}
+	}
+	for i := range steps {
+		visit(i)
+	}
+
+	concurrent := make([][]bool, len(steps))
+	for i := range steps {
+		concurrent[i] = make([]bool, len(steps))
+		for j := range steps {
+			concurrent[i][j] = i != j && !ancestors[i][j] && !ancestors[j][i]
+		}
+	}
+	return concurrent
+}
+
+// helper function returns the peak request. Detached steps
+// run until the pipeline completes, and are always included.
+// For each remaining step, the request is summed with the
+// requests of the steps that can run at the same time, and
+// the maximum sum is used. This is an upper bound of the
+// actual peak, and is exact for serial pipelines.
+func peakRequest(steps []*engine.Step, concurrent [][]bool, t resourceType) int64 {
+	var detached, peak int64
+	for i, step := range steps {
+		if step.Detach {
+			detached += effectiveRequest(step, t)
+			continue
+		}
+		sum := effectiveRequest(step, t)
+		for j, other := range steps {
+			if concurrent[i][j] && !other.Detach {
+				sum += effectiveRequest(other, t)
+			}
+		}
+		if sum > peak {
+			peak = sum
+		}
+	}
+	return detached + peak
+}
+
+// helper function returns the step request. If the step has
+// a limit but no request, Kubernetes defaults the request to
+// the limit. Steps that never run do not request resources.
+func effectiveRequest(step *engine.Step, t resourceType) int64 {
+	if step.RunPolicy == runtime.RunNever {
+		return 0
+	}
+	request, limit := t.get(&step.Resources)
+	if *request == 0 {
+		return limit
+	}
+	return *request
+}
+
+// helper function places the request on as few containers as
+// possible. Containers without limits are filled first, then
+// containers with the largest limits, because a container
+// request cannot exceed the container limit.
+func placeRequest(steps []*engine.Step, request int64, t resourceType) {
+	order := make([]*engine.Step, len(steps))
+	copy(order, steps)
+	sort.SliceStable(order, func(i, j int) bool {
+		_, a := t.get(&order[i].Resources)
+		_, b := t.get(&order[j].Resources)
+		if a == 0 || b == 0 {
+			return a == 0 && b != 0
+		}
+		return a > b
+	})
+
+	for _, step := range order {
+		value, limit := t.get(&step.Resources)
+		v := request
+		if limit > 0 && v > limit {
+			v = limit
+		}
+		request -= v
+		if v == 0 && limit > 0 {
+			v = t.minimum
+			if v > limit {
+				v = limit
+			}
+		}
+		*value = v
+	}
+}
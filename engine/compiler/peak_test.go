@@ -0,0 +1,92 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+)
+
+func testPeakStep(name string, cpu, limit int64, deps ...string) *engine.Step {
+	return &engine.Step{
+		Name:      name,
+		DependsOn: deps,
+		Resources: engine.Resources{
+			Requests: engine.ResourceObject{CPU: cpu},
+			Limits:   engine.ResourceObject{CPU: limit},
+		},
+	}
+}
+
+func testPeakRequests(spec *engine.Spec) (sum int64) {
+	for _, step := range spec.Steps {
+		sum += step.Resources.Requests.CPU
+	}
+	return sum
+}
+
+// This test verifies a serial pipeline requests the resources
+// of the largest step, instead of the sum of all steps.
+func TestPeakRequests_Serial(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			testPeakStep("clone", 100, 0),
+			testPeakStep("build", 1000, 0, "clone"),
+			testPeakStep("test", 500, 0, "build"),
+		},
+	}
+	configurePeakRequests(spec)
+	if got, want := testPeakRequests(spec), int64(1000); got != want {
+		t.Errorf("Want pod requests %d, got %d", want, got)
+	}
+	if got, want := spec.Steps[0].Resources.Requests.CPU, int64(1000); got != want {
+		t.Errorf("Want requests placed on the first container, got %d", got)
+	}
+}
+
+// This test verifies steps that run in parallel, and detached
+// services, are included in the peak requests.
+func TestPeakRequests_Graph(t *testing.T) {
+	service := testPeakStep("redis", 200, 0)
+	service.Detach = true
+
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			testPeakStep("clone", 100, 0),
+			service,
+			testPeakStep("backend", 1000, 0, "clone", "redis"),
+			testPeakStep("frontend", 500, 0, "clone", "redis"),
+			testPeakStep("publish", 100, 0, "backend", "frontend"),
+		},
+	}
+	configurePeakRequests(spec)
+	if got, want := testPeakRequests(spec), int64(1700); got != want {
+		t.Errorf("Want pod requests %d, got %d", want, got)
+	}
+}
+
+// This test verifies the requests do not exceed the container
+// limits, and containers with limits keep a minimal request.
+func TestPeakRequests_Limits(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			testPeakStep("clone", 100, 100),
+			testPeakStep("build", 1000, 1000, "clone"),
+			testPeakStep("test", 500, 500, "build"),
+		},
+	}
+	configurePeakRequests(spec)
+
+	want := []int64{minRequestCPU, 1000, minRequestCPU}
+	for i, step := range spec.Steps {
+		if got := step.Resources.Requests.CPU; got != want[i] {
+			t.Errorf("Want step %s requests %d, got %d", step.Name, want[i], got)
+		}
+		if got := step.Resources.Requests.CPU; got > step.Resources.Limits.CPU {
+			t.Errorf("Want step %s requests within limits, got %d", step.Name, got)
+		}
+	}
+}
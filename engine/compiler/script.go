@@ -11,6 +11,7 @@ import (
 	"github.com/ozonep/drone-runner-kube/engine/compiler/shell"
 	"github.com/ozonep/drone-runner-kube/engine/compiler/shell/powershell"
 	"github.com/ozonep/drone-runner-kube/engine/resource"
+	"github.com/ozonep/drone-runner-kube/pkg/environ"
 )
 
 // helper function configures the pipeline script for the
@@ -54,3 +55,34 @@ func setupScriptPosix(commands []string, dst *engine.Step) {
 	dst.Command = []string{`echo "$DRONE_SCRIPT" | /bin/sh`}
 	dst.Envs["DRONE_SCRIPT"] = shell.Script(commands)
 }
+
+// helper function configures the pipeline scripts to export
+// the outputs of the step dependencies. The outputs of other
+// steps are not exported, and steps without dependencies are
+// unchanged. The clone step does not write outputs.
+//
+// Steps without commands, such as plugins, use the image
+// entrypoint, which cannot be wrapped. These steps receive the
+// outputs as environment variables only if they run in their
+// own pod, in multi-pod mode, because the environment of the
+// pipeline pod containers cannot be changed once created.
+func setupOutputs(spec *engine.Spec, os string) {
+	for _, step := range spec.Steps {
+		script, ok := step.Envs["DRONE_SCRIPT"]
+		if !ok {
+			continue
+		}
+		var prefixes []string
+		for _, name := range dependencies(spec.Steps, step.Name) {
+			if name != "clone" {
+				prefixes = append(prefixes, environ.OutputPrefix(name))
+			}
+		}
+		switch os {
+		case "windows":
+			step.Envs["DRONE_SCRIPT"] = powershell.Outputs(prefixes) + script
+		default:
+			step.Envs["DRONE_SCRIPT"] = shell.Outputs(prefixes) + script
+		}
+	}
+}
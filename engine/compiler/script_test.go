@@ -3,3 +3,36 @@
 // that can be found in the LICENSE file.
 
 package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+)
+
+func TestSetupOutputs(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{Name: "clone", Envs: map[string]string{}},
+			{Name: "build", DependsOn: []string{"clone"}, Envs: map[string]string{"DRONE_SCRIPT": "go build"}},
+			{Name: "lint", DependsOn: []string{"clone"}, Envs: map[string]string{"DRONE_SCRIPT": "go vet"}},
+			{Name: "publish", DependsOn: []string{"build"}, Envs: map[string]string{"DRONE_SCRIPT": "docker push"}},
+		},
+	}
+	setupOutputs(spec, "linux")
+
+	if got, want := spec.Steps[1].Envs["DRONE_SCRIPT"], "go build"; got != want {
+		t.Errorf("Want script unchanged for steps without outputs, got %q", got)
+	}
+	script := spec.Steps[3].Envs["DRONE_SCRIPT"]
+	if !strings.Contains(script, "DRONE_OUTPUT_BUILD_*=*)") {
+		t.Errorf("Want outputs of the build step exported, got %q", script)
+	}
+	if strings.Contains(script, "DRONE_OUTPUT_LINT_") || strings.Contains(script, "DRONE_OUTPUT_CLONE_") {
+		t.Errorf("Want outputs of other steps not exported, got %q", script)
+	}
+	if !strings.HasSuffix(script, "docker push") {
+		t.Errorf("Want outputs exported before the script, got %q", script)
+	}
+}
@@ -31,6 +31,17 @@ func Script(commands []string) string {
 	return buf.String()
 }
 
+// Outputs returns a powershell script that sets the step
+// outputs with the given environment variable prefixes. The
+// outputs are read from the downward api file, where the
+// values are base64 encoded.
+func Outputs(prefixes []string) string {
+	if len(prefixes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(outputScript, strings.Join(prefixes, "|"))
+}
+
 // optionScript is a helper script this is added to the build
 // to set shell options, in this case, to exit on error.
 const optionScript = `
@@ -50,6 +61,19 @@ password $Env:DRONE_NETRC_PASSWORD
 $erroractionpreference = "stop"
 `
 
+// outputScript is a helper script that is added to the build
+// to set the outputs of the step dependencies.
+const outputScript = `
+if (Test-Path /run/drone/env) {
+	foreach ($line in Get-Content /run/drone/env) {
+		if ($line -match '^((%s)[^=]*)="(.*)"$') {
+			$value = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String($Matches[3]))
+			[Environment]::SetEnvironmentVariable($Matches[1], $value)
+		}
+	}
+}
+`
+
 // traceScript is a helper script that is added to
 // the build script to trace a command.
 const traceScript = `
@@ -31,6 +31,21 @@ func Script(commands []string) string {
 	return buf.String()
 }
 
+// Outputs returns a posix-compliant shell script that exports
+// the step outputs with the given environment variable prefixes.
+// The outputs are read from the downward api file, where the
+// values are base64 encoded.
+func Outputs(prefixes []string) string {
+	if len(prefixes) == 0 {
+		return ""
+	}
+	var patterns []string
+	for _, prefix := range prefixes {
+		patterns = append(patterns, prefix+"*=*")
+	}
+	return fmt.Sprintf(outputScript, strings.Join(patterns, "|"))
+}
+
 // optionScript is a helper script this is added to the build
 // to set shell options, in this case, to exit on error.
 const optionScript = `
@@ -48,6 +63,26 @@ unset DRONE_NETRC_FILE
 set -e
 `
 
+// outputScript is a helper script that is added to the build
+// to export the outputs of the step dependencies. A trailing
+// character is appended to the decoded value to preserve
+// trailing newlines.
+const outputScript = `
+if [ -f /run/drone/env ]; then
+	while IFS= read -r line; do
+		case "$line" in
+		%s)
+			key="${line%%%%=*}"
+			value="${line#*=}"
+			value="$(echo "$value" | tr -d '"' | base64 -d; echo .)"
+			export "$key=${value%%.}"
+			;;
+		esac
+	done < /run/drone/env
+	unset line key value
+fi
+`
+
 // traceScript is a helper script that is added to
 // the build script to trace a command.
 const traceScript = `
@@ -6,6 +6,7 @@ package compiler
 
 import (
 	"strings"
+	"time"
 
 	"github.com/ozonep/drone-runner-kube/engine"
 	"github.com/ozonep/drone-runner-kube/engine/resource"
@@ -17,10 +18,15 @@ import (
 
 const placeholderImage = "drone/placeholder:1"
 
+// default duration the engine waits for a service to be
+// ready, if the timeout is not configured in the yaml.
+const readyTimeout = 5 * time.Minute
+
 func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 	dst := &engine.Step{
 		ID:           random(),
 		Name:         src.Name,
+		OnCancel:     src.OnCancel,
 		Image:        image.Expand(src.Image),
 		Placeholder:  placeholderImage,
 		Command:      src.Command,
@@ -39,6 +45,33 @@ func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 		WorkingDir:   src.WorkingDir,
 	}
 
+	// appends the readiness check. The engine waits for the
+	// service to be ready before dependent steps start.
+	if ready := src.Ready; ready != nil {
+		dst.Ready = &engine.Ready{
+			TCP:     ready.TCP,
+			Exec:    ready.Exec,
+			Timeout: ready.Timeout,
+		}
+		if ready.HTTP != nil {
+			dst.Ready.HTTP = &engine.ReadyHTTP{
+				Port: ready.HTTP.Port,
+				Path: ready.HTTP.Path,
+			}
+		}
+		if dst.Ready.Timeout == 0 {
+			dst.Ready.Timeout = readyTimeout
+		}
+	}
+
+	// appends the ports exposed by the service.
+	for _, port := range src.Ports {
+		dst.Ports = append(dst.Ports, engine.Port{
+			Port:     port.Port,
+			Protocol: port.Protocol,
+		})
+	}
+
 	// appends the volumes to the container def.
 	for _, vol := range src.Volumes {
 		dst.Volumes = append(dst.Volumes, &engine.VolumeMount{
@@ -57,6 +57,34 @@ func configureSerial(spec *engine.Spec) {
 	}
 }
 
+// helper function returns the names of the direct and indirect
+// dependencies of the named step.
+func dependencies(steps []*engine.Step, name string) []string {
+	index := map[string]*engine.Step{}
+	for _, step := range steps {
+		index[step.Name] = step
+	}
+	var names []string
+	visited := map[string]bool{name: true}
+	var visit func(name string)
+	visit = func(name string) {
+		step, ok := index[name]
+		if !ok {
+			return
+		}
+		for _, dep := range step.DependsOn {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			visit(dep)
+			names = append(names, dep)
+		}
+	}
+	visit(name)
+	return names
+}
+
 // helper function converts the environment variables to a map,
 // returning only inline environment variables not derived from
 // a secret.
@@ -120,6 +148,24 @@ func configureCloneDeps(spec *engine.Spec) {
 	}
 }
 
+// helper function modifies the pipeline dependency graph to
+// run the init steps in order, before all other steps.
+func configureInitDeps(spec *engine.Spec, inits []*engine.Step) {
+	var prev *engine.Step
+	for _, step := range inits {
+		step.DependsOn = nil
+		if prev != nil {
+			step.DependsOn = []string{prev.Name}
+		}
+		prev = step
+	}
+	for _, step := range spec.Steps {
+		if len(step.DependsOn) == 0 {
+			step.DependsOn = []string{prev.Name}
+		}
+	}
+}
+
 // helper function modifies the pipeline dependency graph to
 // account for a disabled clone step.
 func removeCloneDeps(spec *engine.Spec) {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/ozonep/drone-runner-kube/engine"
 	"github.com/ozonep/drone-runner-kube/engine/resource"
+	"github.com/ozonep/drone-runner-kube/pkg/manifest"
 )
 
 const (
@@ -17,6 +18,10 @@ const (
 	workspaceHostName = "host"
 )
 
+// default size of the workspace claim, if the size is not
+// configured in the yaml or by the runner.
+const workspaceClaimSize = 10 << 30 // 10 GiB
+
 func createWorkspace(from *resource.Pipeline) string {
 	path := workspacePath
 	if from.Workspace.Path != "" {
@@ -28,6 +33,80 @@ func createWorkspace(from *resource.Pipeline) string {
 	return path
 }
 
+// helper function creates the workspace volume. The volume
+// configured in the yaml is used if defined, otherwise the
+// runner defaults are used.
+func createWorkspaceVolume(from *resource.Pipeline, defaults Workspace, name string) *engine.Volume {
+	config := from.Workspace.Volume
+
+	// in multi-pod mode, the steps run in separate pods that
+	// may be scheduled to different nodes, and the workspace
+	// must be backed by a claim that can be mounted by all
+	// pods at the same time.
+	if from.MultiPod && (config == nil || config.Claim == nil) {
+		config = &resource.WorkspaceVolume{
+			Claim: &resource.WorkspaceClaim{},
+		}
+	}
+
+	if config == nil {
+		config = &resource.WorkspaceVolume{}
+		if defaults.ClaimSize > 0 {
+			config.Claim = &resource.WorkspaceClaim{
+				StorageClass: defaults.StorageClass,
+				Size:         manifest.BytesSize(defaults.ClaimSize),
+			}
+		} else {
+			config.EmptyDir = &resource.VolumeEmptyDir{
+				SizeLimit: manifest.BytesSize(defaults.SizeLimit),
+			}
+		}
+	}
+
+	if claim := config.Claim; claim != nil {
+		size := int64(claim.Size)
+		if size == 0 {
+			size = defaults.ClaimSize
+		}
+		if size == 0 {
+			size = workspaceClaimSize
+		}
+		storageClass := claim.StorageClass
+		if storageClass == "" {
+			storageClass = defaults.StorageClass
+		}
+		volume := &engine.Volume{
+			Claim: &engine.VolumeClaim{
+				ID:           random(),
+				Name:         name,
+				ClaimName:    random(),
+				Create:       true,
+				StorageClass: storageClass,
+				Size:         size,
+			},
+		}
+		if from.MultiPod {
+			volume.Claim.AccessMode = "ReadWriteMany"
+		}
+		return volume
+	}
+
+	volume := &engine.Volume{
+		EmptyDir: &engine.VolumeEmptyDir{
+			ID:   random(),
+			Name: name,
+		},
+	}
+	if temp := config.EmptyDir; temp != nil {
+		volume.EmptyDir.Medium = temp.Medium
+		volume.EmptyDir.SizeLimit = int64(temp.SizeLimit)
+	}
+	if volume.EmptyDir.SizeLimit == 0 {
+		volume.EmptyDir.SizeLimit = defaults.SizeLimit
+	}
+	return volume
+}
+
 func setupWorkdir(src *resource.Step, dst *engine.Step, path string) {
 	// if the working directory is already set
 	// do not alter.
@@ -10,6 +10,9 @@ import (
 	"github.com/ozonep/drone-runner-kube/engine"
 	"github.com/ozonep/drone-runner-kube/engine/resource"
 	"github.com/ozonep/drone-runner-kube/pkg/manifest"
+
+	"github.com/dchest/uniuri"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestSetupWorkspace(t *testing.T) {
@@ -105,3 +108,131 @@ func TestCreateWorkspace(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateWorkspaceVolume(t *testing.T) {
+	random = notRandom
+	defer func() {
+		random = uniuri.New
+	}()
+
+	tests := []struct {
+		from     *resource.Pipeline
+		defaults Workspace
+		want     *engine.Volume
+	}{
+		// the workspace is a temporary directory by default.
+		{
+			from: &resource.Pipeline{},
+			defaults: Workspace{
+				SizeLimit: 1 << 30,
+			},
+			want: &engine.Volume{
+				EmptyDir: &engine.VolumeEmptyDir{
+					ID:        "random",
+					Name:      "_workspace",
+					SizeLimit: 1 << 30,
+				},
+			},
+		},
+		// the runner can back the workspace with a claim by
+		// default.
+		{
+			from: &resource.Pipeline{},
+			defaults: Workspace{
+				StorageClass: "standard",
+				ClaimSize:    5 << 30,
+			},
+			want: &engine.Volume{
+				Claim: &engine.VolumeClaim{
+					ID:           "random",
+					Name:         "_workspace",
+					ClaimName:    "random",
+					Create:       true,
+					StorageClass: "standard",
+					Size:         5 << 30,
+				},
+			},
+		},
+		// the yaml configuration overrides the runner defaults.
+		{
+			from: &resource.Pipeline{
+				Workspace: resource.Workspace{
+					Volume: &resource.WorkspaceVolume{
+						Claim: &resource.WorkspaceClaim{
+							StorageClass: "fast",
+						},
+					},
+				},
+			},
+			defaults: Workspace{
+				SizeLimit: 1 << 30,
+			},
+			want: &engine.Volume{
+				Claim: &engine.VolumeClaim{
+					ID:           "random",
+					Name:         "_workspace",
+					ClaimName:    "random",
+					Create:       true,
+					StorageClass: "fast",
+					Size:         workspaceClaimSize,
+				},
+			},
+		},
+		{
+			from: &resource.Pipeline{
+				Workspace: resource.Workspace{
+					Volume: &resource.WorkspaceVolume{
+						EmptyDir: &resource.VolumeEmptyDir{
+							SizeLimit: 2 << 30,
+						},
+					},
+				},
+			},
+			defaults: Workspace{
+				StorageClass: "standard",
+				ClaimSize:    5 << 30,
+			},
+			want: &engine.Volume{
+				EmptyDir: &engine.VolumeEmptyDir{
+					ID:        "random",
+					Name:      "_workspace",
+					SizeLimit: 2 << 30,
+				},
+			},
+		},
+		// the workspace is shared by the step pods in multi-pod
+		// mode, and is always a claim that can be mounted by
+		// multiple nodes.
+		{
+			from: &resource.Pipeline{
+				MultiPod: true,
+				Workspace: resource.Workspace{
+					Volume: &resource.WorkspaceVolume{
+						EmptyDir: &resource.VolumeEmptyDir{},
+					},
+				},
+			},
+			defaults: Workspace{
+				StorageClass: "nfs",
+			},
+			want: &engine.Volume{
+				Claim: &engine.VolumeClaim{
+					ID:           "random",
+					Name:         "_workspace",
+					ClaimName:    "random",
+					Create:       true,
+					StorageClass: "nfs",
+					Size:         workspaceClaimSize,
+					AccessMode:   "ReadWriteMany",
+				},
+			},
+		},
+	}
+	for i, test := range tests {
+		got := createWorkspaceVolume(test.from, test.defaults, "_workspace")
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Unexpected workspace volume at index %d", i)
+			t.Log(diff)
+		}
+	}
+}
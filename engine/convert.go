@@ -5,13 +5,22 @@
 package engine
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// DeadlineGrace defines the grace period added to the pipeline
+// timeout when calculating the pod active deadline. The pod
+// deadline should only be reached if the runner is unable
+// to enforce the pipeline timeout.
+const DeadlineGrace = 10 * time.Minute
+
 func toPod(spec *Spec) *v1.Pod {
 	return &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -23,18 +32,37 @@ func toPod(spec *Spec) *v1.Pod {
 		Spec: v1.PodSpec{
 			ServiceAccountName: spec.PodSpec.ServiceAccountName,
 			RestartPolicy:      v1.RestartPolicyNever,
-			Volumes:            toVolumes(spec),
+			Volumes:            append(toVolumes(spec), toShimVolumes(spec)...),
+			InitContainers:     append(toShimInitContainers(spec), toInitContainers(spec)...),
 			Containers:         toContainers(spec),
 			NodeName:           spec.PodSpec.NodeName,
 			NodeSelector:       spec.PodSpec.NodeSelector,
 			Tolerations:        toTolerations(spec),
 			ImagePullSecrets:   toImagePullSecrets(spec),
-			HostAliases:        toHostAliases(spec),
+			HostAliases:        toPodHostAliases(spec),
 			DNSConfig:          toDnsConfig(spec),
+
+			ActiveDeadlineSeconds:         toActiveDeadline(spec),
+			TerminationGracePeriodSeconds: toGracePeriodSeconds(spec),
 		},
 	}
 }
 
+// helper function returns the pod active deadline, in seconds,
+// derived from the pipeline timeout. In debug mode the pod is
+// held after a step fails, so the deadline is extended by the
+// debug timeout.
+func toActiveDeadline(spec *Spec) *int64 {
+	ttl, err := time.ParseDuration(spec.PodSpec.Annotations["io.drone.ttl"])
+	if err != nil || ttl <= 0 {
+		return nil
+	}
+	if spec.Debug != nil {
+		ttl += spec.Debug.Timeout
+	}
+	return int64ptr(int64((ttl + DeadlineGrace).Seconds()))
+}
+
 func toDnsConfig(spec *Spec) *v1.PodDNSConfig {
 	var dnsOptions []v1.PodDNSConfigOption
 	if len(spec.PodSpec.DnsConfig.Options) > 0 {
@@ -53,9 +81,20 @@ func toDnsConfig(spec *Spec) *v1.PodDNSConfig {
 	}
 }
 
-func toHostAliases(spec *Spec) []v1.HostAlias {
+// helper function returns the pipeline pod host aliases. If
+// the services run in separate pods, the service names are
+// resolved to the service addresses.
+func toPodHostAliases(spec *Spec) []v1.HostAlias {
+	aliases := spec.PodSpec.HostAliases
+	if spec.ServicePods {
+		aliases = append(aliases[:len(aliases):len(aliases)], spec.aliases...)
+	}
+	return toHostAliases(aliases)
+}
+
+func toHostAliases(aliases []HostAlias) []v1.HostAlias {
 	var hostAliases []v1.HostAlias
-	for _, hostAlias := range spec.PodSpec.HostAliases {
+	for _, hostAlias := range aliases {
 		if len(hostAlias.Hostnames) > 0 {
 			hostAliases = append(hostAliases, v1.HostAlias{
 				IP:        hostAlias.IP,
@@ -90,9 +129,9 @@ func toVolumes(spec *Spec) []v1.Volume {
 			source := &v1.EmptyDirVolumeSource{}
 			if strings.EqualFold(v.EmptyDir.Medium, "memory") {
 				source.Medium = v1.StorageMediumMemory
-				if v.EmptyDir.SizeLimit > int64(0) {
-					source.SizeLimit = resource.NewQuantity(v.EmptyDir.SizeLimit, resource.BinarySI)
-				}
+			}
+			if v.EmptyDir.SizeLimit > int64(0) {
+				source.SizeLimit = resource.NewQuantity(v.EmptyDir.SizeLimit, resource.BinarySI)
 			}
 			volume := v1.Volume{
 				Name: v.EmptyDir.ID,
@@ -162,25 +201,65 @@ func toContainers(spec *Spec) []v1.Container {
 	var containers []v1.Container
 
 	for _, s := range spec.Steps {
-		container := v1.Container{
-			Name:            s.ID,
-			Image:           s.Placeholder,
-			Command:         s.Entrypoint,
-			Args:            s.Command,
-			ImagePullPolicy: toPullPolicy(s.Pull),
-			WorkingDir:      s.WorkingDir,
-			Resources:       toResources(s.Resources),
-			SecurityContext: toSecurityContext(s),
-			VolumeMounts:    toVolumeMounts(spec, s),
-			Env:             toEnv(spec, s),
+		if s.Init || isSeparatePod(spec, s) {
+			continue
 		}
+		container := toContainer(spec, s)
+		if isShimStep(spec, s) {
+			toShimContainer(spec, s, &container)
+		}
+		containers = append(containers, container)
+	}
+
+	// if all steps run in separate pods, the pipeline pod
+	// is kept running with a placeholder container.
+	if len(containers) == 0 && len(spec.Steps) > 0 {
+		containers = append(containers, toPipelineContainer(spec))
+	}
+
+	// the debug container is idle until a step fails and
+	// debug mode is enabled.
+	if spec.Debug != nil && spec.Debug.Step != nil {
+		containers = append(containers, toContainer(spec, spec.Debug.Step))
+	}
 
+	return containers
+}
+
+func toInitContainers(spec *Spec) []v1.Container {
+	var containers []v1.Container
+
+	for _, s := range spec.Steps {
+		if !s.Init {
+			continue
+		}
+		// init containers run before the pod containers start,
+		// and are created with the step image instead of the
+		// placeholder image.
+		container := toContainer(spec, s)
+		container.Image = s.Image
 		containers = append(containers, container)
 	}
 
 	return containers
 }
 
+func toContainer(spec *Spec, s *Step) v1.Container {
+	return v1.Container{
+		Name:            s.ID,
+		Image:           s.Placeholder,
+		Command:         s.Entrypoint,
+		Args:            s.Command,
+		ImagePullPolicy: toPullPolicy(s.Pull),
+		WorkingDir:      s.WorkingDir,
+		Resources:       toResources(s.Resources),
+		SecurityContext: toSecurityContext(s),
+		VolumeMounts:    toVolumeMounts(spec, s),
+		Env:             toEnv(spec, s),
+		ReadinessProbe:  toReadinessProbe(s),
+	}
+}
+
 func toEnv(spec *Spec, step *Step) []v1.EnvVar {
 	var envVars []v1.EnvVar
 
@@ -238,7 +317,9 @@ func toSecret(spec *Spec) *v1.Secret {
 
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: spec.PodSpec.Name,
+			Name:        spec.PodSpec.Name,
+			Labels:      spec.PodSpec.Labels,
+			Annotations: spec.PodSpec.Annotations,
 		},
 		Type:       "Opaque",
 		StringData: stringData,
@@ -248,7 +329,9 @@ func toSecret(spec *Spec) *v1.Secret {
 func toDockerConfigSecret(spec *Spec) *v1.Secret {
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: spec.PullSecret.Name,
+			Name:        spec.PullSecret.Name,
+			Labels:      spec.PodSpec.Labels,
+			Annotations: spec.PodSpec.Annotations,
 		},
 		Type: "kubernetes.io/dockerconfigjson",
 		StringData: map[string]string{
@@ -257,6 +340,90 @@ func toDockerConfigSecret(spec *Spec) *v1.Secret {
 	}
 }
 
+// helper function returns the pod annotations that expose
+// the current pipeline status, and the outputs of the step
+// dependencies, to the step with the downward api. The
+// container environment cannot be updated once the pod is
+// created. The outputs are base64 encoded, and are decoded by
+// the step script.
+func toStepAnnotations(step *Step) map[string]string {
+	annotations := map[string]string{}
+	for _, env := range statusesWhiteList {
+		annotations[env] = step.Envs[env]
+	}
+	for env, value := range step.Envs {
+		if isOutputEnv(env) {
+			annotations[env] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+	}
+	return annotations
+}
+
+// helper function returns the persistent volume claims that
+// are created with the pipeline environment.
+func toPersistentVolumeClaims(spec *Spec) []*v1.PersistentVolumeClaim {
+	var claims []*v1.PersistentVolumeClaim
+	for _, v := range spec.Volumes {
+		if v.Claim == nil || !v.Claim.Create {
+			continue
+		}
+		claim := &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        v.Claim.ClaimName,
+				Labels:      spec.PodSpec.Labels,
+				Annotations: spec.PodSpec.Annotations,
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{
+					toAccessMode(v.Claim.AccessMode),
+				},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceStorage: *resource.NewQuantity(v.Claim.Size, resource.BinarySI),
+					},
+				},
+			},
+		}
+		if v.Claim.StorageClass != "" {
+			claim.Spec.StorageClassName = stringptr(v.Claim.StorageClass)
+		}
+		claims = append(claims, claim)
+	}
+	return claims
+}
+
+// helper function returns the claim access mode. The claim
+// is read-write by a single node by default.
+func toAccessMode(mode string) v1.PersistentVolumeAccessMode {
+	if mode == "" {
+		return v1.ReadWriteOnce
+	}
+	return v1.PersistentVolumeAccessMode(mode)
+}
+
+// helper function returns a merge patch that sets the pod
+// as the owner of an object, so the object is garbage
+// collected when the pod is deleted.
+func toOwnerPatch(pod *v1.Pod) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{
+				toOwnerReference(pod),
+			},
+		},
+	})
+}
+
+// helper function returns an owner reference to the pod.
+func toOwnerReference(pod *v1.Pod) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
+
 func toImagePullSecrets(spec *Spec) []v1.LocalObjectReference {
 	var pullSecrets []v1.LocalObjectReference
 	if spec.PullSecret != nil {
@@ -314,11 +481,12 @@ func toResources(src Resources) v1.ResourceRequirements {
 
 // helper function returns a kubernetes namespace
 // for the given specification.
-func toNamespace(name string, labels map[string]string) *v1.Namespace {
+func toNamespace(name string, labels, annotations map[string]string) *v1.Namespace {
 	return &v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   name,
-			Labels: labels,
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 	}
 }
@@ -378,6 +546,6 @@ func boolptr(v bool) *bool {
 	return &v
 }
 
-// func stringptr(v string) *string {
-// 	return &v
-// }
+func stringptr(v string) *string {
+	return &v
+}
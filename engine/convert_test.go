@@ -7,6 +7,7 @@ package engine
 import (
 	v1 "k8s.io/api/core/v1"
 	"testing"
+	"time"
 )
 
 func TestSecurityContext(t *testing.T) {
@@ -30,3 +31,89 @@ func TestSecurityContext(t *testing.T) {
 		t.Error("security context was not converted to expected values")
 	}
 }
+
+func TestActiveDeadline(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Annotations: map[string]string{
+				"io.drone.ttl": "1h0m0s",
+			},
+		},
+	}
+	got := toActiveDeadline(spec)
+	if got == nil {
+		t.Fatalf("Expect active deadline")
+	}
+	if want := int64(4200); *got != want {
+		t.Errorf("Want active deadline %d, got %d", want, *got)
+	}
+
+	// the deadline covers the time the pod is held in
+	// debug mode.
+	spec.Debug = &Debug{Timeout: 30 * time.Minute}
+	if got, want := *toActiveDeadline(spec), int64(6000); got != want {
+		t.Errorf("Want active deadline %d in debug mode, got %d", want, got)
+	}
+
+	spec.PodSpec.Annotations["io.drone.ttl"] = "0s"
+	if got := toActiveDeadline(spec); got != nil {
+		t.Errorf("Expect no active deadline when timeout is zero")
+	}
+}
+
+func TestOwnerPatch(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Name = "drone-test"
+	pod.UID = "dbd0b946-2b52-4a4f-9e35-0b1f2f9e1c4a"
+
+	got, err := toOwnerPatch(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"metadata":{"ownerReferences":[{"apiVersion":"v1","kind":"Pod","name":"drone-test","uid":"dbd0b946-2b52-4a4f-9e35-0b1f2f9e1c4a"}]}}`
+	if string(got) != want {
+		t.Errorf("Want patch %s, got %s", want, got)
+	}
+}
+
+func TestInitContainers(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "step1", Name: "init", Init: true, Image: "alpine", Placeholder: ""},
+			{ID: "step2", Name: "build", Image: "golang", Placeholder: "drone/placeholder:1"},
+		},
+	}
+	pod := toPod(spec)
+	if got, want := len(pod.Spec.InitContainers), 1; got != want {
+		t.Fatalf("Want %d init containers, got %d", want, got)
+	}
+	if got, want := pod.Spec.InitContainers[0].Image, "alpine"; got != want {
+		t.Errorf("Want init container image %s, got %s", want, got)
+	}
+	if got, want := len(pod.Spec.Containers), 1; got != want {
+		t.Fatalf("Want %d containers, got %d", want, got)
+	}
+	if got, want := pod.Spec.Containers[0].Image, "drone/placeholder:1"; got != want {
+		t.Errorf("Want container image %s, got %s", want, got)
+	}
+}
+
+func TestStepAnnotations(t *testing.T) {
+	step := &Step{
+		Envs: map[string]string{
+			"DRONE_BUILD_STATUS":         "success",
+			"DRONE_OUTPUT_BUILD_VERSION": `say "hello"\n`,
+			"DRONE_COMMIT_MESSAGE":       "initial commit",
+		},
+	}
+	annotations := toStepAnnotations(step)
+	if got, want := annotations["DRONE_BUILD_STATUS"], "success"; got != want {
+		t.Errorf("Want status annotation %s, got %s", want, got)
+	}
+	if got, want := annotations["DRONE_OUTPUT_BUILD_VERSION"], "c2F5ICJoZWxsbyJcbg=="; got != want {
+		t.Errorf("Want base64 encoded output annotation %s, got %s", want, got)
+	}
+	if _, ok := annotations["DRONE_COMMIT_MESSAGE"]; ok {
+		t.Errorf("Want environment variables not exposed as annotations")
+	}
+}
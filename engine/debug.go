@@ -0,0 +1,85 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+)
+
+// DebugDoneFile is the file that ends the debug session
+// early when created in the debug container.
+const DebugDoneFile = "/tmp/drone-debug-done"
+
+// Debug holds the pod after the step fails, so the step can
+// be debugged interactively. The debug container is started
+// with the image of the failed step, and instructions to
+// attach to the container are written to the step logs. The
+// session ends when the debug timeout is exceeded, or when
+// the debug container exits.
+func (k *Kubernetes) Debug(ctx context.Context, specv runtime.Spec, stepv runtime.Step, output io.Writer) error {
+	spec := specv.(*Spec)
+	step := stepv.(*Step)
+
+	if spec.Debug == nil || spec.Debug.Step == nil {
+		return nil
+	}
+
+	k, err := k.cluster(spec.Cluster)
+	if err != nil {
+		return err
+	}
+
+	// the debug container can only be started once, so only
+	// the first failed step can be debugged.
+	var first bool
+	spec.debugOnce.Do(func() { first = true })
+	if !first {
+		io.WriteString(output, "\ndebug mode: another failed step is being debugged\n")
+		return nil
+	}
+
+	if spec.Debug.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Debug.Timeout)
+		defer cancel()
+	}
+
+	// the container command, environment and volumes cannot
+	// be changed once the pod is created, so the debug
+	// container is only updated to use the failed step image.
+	// the step environment is used to update the pod status
+	// annotations.
+	debug := *spec.Debug.Step
+	debug.Image = step.Image
+	debug.Envs = step.Envs
+
+	if err := k.start(spec, &debug); err != nil {
+		return err
+	}
+	if err := k.waitForReady(ctx, spec, &debug); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output, "\ndebug mode: the pod is held for %s so the failed step can be debugged.\n", spec.Debug.Timeout)
+	fmt.Fprintf(output, "debug mode: to open a shell in the step image, with the workspace mounted, run:\n\n")
+	fmt.Fprintf(output, "    kubectl exec -it --namespace %s %s --container %s -- /bin/sh\n\n",
+		spec.PodSpec.Namespace, spec.PodSpec.Name, debug.ID)
+	fmt.Fprintf(output, "debug mode: to end the debug session early, run:\n\n")
+	fmt.Fprintf(output, "    kubectl exec --namespace %s %s --container %s -- touch %s\n\n",
+		spec.PodSpec.Namespace, spec.PodSpec.Name, debug.ID, DebugDoneFile)
+
+	// the debug session ending because the timeout is
+	// exceeded, or the pipeline is cancelled, is expected.
+	_, err = k.waitForTerminated(ctx, spec, &debug)
+	if ctx.Err() != nil {
+		err = nil
+	}
+	io.WriteString(output, "debug mode: the debug session ended\n")
+	return err
+}
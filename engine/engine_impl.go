@@ -10,23 +10,22 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
-	"github.com/ozonep/drone-runner-kube/internal/docker/image"
+	"github.com/ozonep/drone-runner-kube/internal/shim"
 	"github.com/ozonep/drone-runner-kube/pkg/livelog"
+	"github.com/ozonep/drone-runner-kube/pkg/logger"
 	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
-	watchtools "k8s.io/client-go/tools/watch"
 	"k8s.io/client-go/util/retry"
 )
 
@@ -39,18 +38,47 @@ var backoff = wait.Backoff{
 
 // Kubernetes implements a Kubernetes pipeline engine.
 type Kubernetes struct {
-	client *kubernetes.Clientset
+	client   kubernetes.Interface
+	pods     *podInformer
+	logs     logClient
+	metrics  metricsClient
+	clusters map[string]*Kubernetes
+	once     sync.Once
+
+	// PendingTimeout defines the maximum amount of time a
+	// step can remain pending, waiting to be scheduled or
+	// for its image to be pulled, before it is failed. A
+	// zero value disables the timeout.
+	PendingTimeout time.Duration
+
+	// UsageInterval defines the interval the resource usage
+	// of the running steps is sampled from the metrics api.
+	// A zero value disables sampling.
+	UsageInterval time.Duration
+}
+
+// ClientConfig provides the Kubernetes client configuration.
+type ClientConfig struct {
+	// QPS defines the maximum number of queries per second
+	// sent to the Kubernetes API server. A zero value uses
+	// the client default.
+	QPS float32
+
+	// Burst defines the maximum burst of queries sent to
+	// the Kubernetes API server. A zero value uses the
+	// client default.
+	Burst int
 }
 
 // New returns a new engine.
-func New() (*Kubernetes, error) {
-	engine, err := NewInCluster()
+func New(cc ClientConfig) (*Kubernetes, error) {
+	engine, err := NewInCluster(cc)
 	if err == nil {
 		return engine, nil
 	}
 	dir, _ := os.UserHomeDir()
 	dir = filepath.Join(dir, ".kube", "config")
-	engine, xerr := NewFromConfig(dir)
+	engine, xerr := NewFromConfig(dir, cc)
 	if xerr == nil {
 		return engine, nil
 	}
@@ -58,100 +86,418 @@ func New() (*Kubernetes, error) {
 }
 
 // NewFromConfig returns a new out-of-cluster engine.
-func NewFromConfig(path string) (*Kubernetes, error) {
+func NewFromConfig(path string, cc ClientConfig) (*Kubernetes, error) {
 	// use the current context in kubeconfig
 	config, err := clientcmd.BuildConfigFromFlags("", path)
 	if err != nil {
 		return nil, err
 	}
+	return newFromRestConfig(config, cc)
+}
 
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
+// NewFromContext returns a new out-of-cluster engine using
+// the named context in kubeconfig.
+func NewFromContext(path, context string, cc ClientConfig) (*Kubernetes, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
 	if err != nil {
 		return nil, err
 	}
-	return &Kubernetes{
-		client: clientset,
-	}, nil
+	return newFromRestConfig(config, cc)
 }
 
 // NewInCluster returns a new in-cluster engine.
-func NewInCluster() (*Kubernetes, error) {
+func NewInCluster(cc ClientConfig) (*Kubernetes, error) {
 	// creates the in-cluster config
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
+	return newFromRestConfig(config, cc)
+}
+
+// helper function returns a new engine from the rest
+// configuration.
+func newFromRestConfig(config *rest.Config, cc ClientConfig) (*Kubernetes, error) {
+	if cc.QPS > 0 {
+		config.QPS = cc.QPS
+	}
+	if cc.Burst > 0 {
+		config.Burst = cc.Burst
+	}
 	// creates the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 	return &Kubernetes{
-		client: clientset,
+		client:  clientset,
+		pods:    newPodInformer(clientset),
+		logs:    &restLogs{client: clientset.CoreV1().RESTClient()},
+		metrics: &restMetrics{client: clientset.CoreV1().RESTClient()},
 	}, nil
 }
 
-// Setup the pipeline environment.
+// Setup the pipeline environment. Transient errors are
+// retried, and if the setup fails, the objects that were
+// already created are deleted.
 func (k *Kubernetes) Setup(ctx context.Context, specv runtime.Spec) error {
 	spec := specv.(*Spec)
 
+	k, err := k.cluster(spec.Cluster)
+	if err != nil {
+		return err
+	}
+
+	// the pod informer of the namespace is held until the
+	// pipeline environment is destroyed, so the informer is
+	// shared by the steps and not restarted for each step.
+	if !spec.informed {
+		k.informer().acquire(spec.PodSpec.Namespace)
+		spec.informed = true
+	}
+
+	namespaces := k.client.CoreV1().Namespaces()
+	secrets := k.client.CoreV1().Secrets(spec.PodSpec.Namespace)
+	pods := k.client.CoreV1().Pods(spec.PodSpec.Namespace)
+	claims := k.client.CoreV1().PersistentVolumeClaims(spec.PodSpec.Namespace)
+	services := k.client.CoreV1().Services(spec.PodSpec.Namespace)
+	budgets := k.client.PolicyV1beta1().PodDisruptionBudgets(spec.PodSpec.Namespace)
+
+	var objects []*object
 	if spec.Namespace != "" {
-		_, err := k.client.CoreV1().Namespaces().Create(toNamespace(spec.Namespace, spec.PodSpec.Labels))
-		if err != nil {
-			return err
-		}
+		objects = append(objects, &object{
+			kind: "namespace",
+			name: spec.Namespace,
+			create: func() error {
+				_, err := namespaces.Create(toNamespace(spec.Namespace, spec.PodSpec.Labels, spec.PodSpec.Annotations))
+				return err
+			},
+			get: func() (metav1.Object, error) {
+				return namespaces.Get(spec.Namespace, metav1.GetOptions{})
+			},
+			delete: func() error {
+				return namespaces.Delete(spec.Namespace, &metav1.DeleteOptions{})
+			},
+		})
 	}
 
 	if spec.PullSecret != nil {
-		_, err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Create(toDockerConfigSecret(spec))
-		if err != nil {
+		objects = append(objects, &object{
+			kind: "secret",
+			name: spec.PullSecret.Name,
+			create: func() error {
+				_, err := secrets.Create(toDockerConfigSecret(spec))
+				return err
+			},
+			get: func() (metav1.Object, error) {
+				return secrets.Get(spec.PullSecret.Name, metav1.GetOptions{})
+			},
+			delete: func() error {
+				return secrets.Delete(spec.PullSecret.Name, &metav1.DeleteOptions{})
+			},
+			patch: func(data []byte) error {
+				_, err := secrets.Patch(spec.PullSecret.Name, types.MergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	objects = append(objects, &object{
+		kind: "secret",
+		name: spec.PodSpec.Name,
+		create: func() error {
+			_, err := secrets.Create(toSecret(spec))
+			return err
+		},
+		get: func() (metav1.Object, error) {
+			return secrets.Get(spec.PodSpec.Name, metav1.GetOptions{})
+		},
+		delete: func() error {
+			return secrets.Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+		},
+		patch: func(data []byte) error {
+			_, err := secrets.Patch(spec.PodSpec.Name, types.MergePatchType, data)
+			return err
+		},
+	})
+
+	for _, claim := range toPersistentVolumeClaims(spec) {
+		claim := claim
+		objects = append(objects, &object{
+			kind: "persistentvolumeclaim",
+			name: claim.Name,
+			create: func() error {
+				_, err := claims.Create(claim)
+				return err
+			},
+			get: func() (metav1.Object, error) {
+				return claims.Get(claim.Name, metav1.GetOptions{})
+			},
+			delete: func() error {
+				return claims.Delete(claim.Name, &metav1.DeleteOptions{})
+			},
+			patch: func(data []byte) error {
+				_, err := claims.Patch(claim.Name, types.MergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	// in multi-pod mode, the services are exposed to the step
+	// pods with cluster services. The service addresses are
+	// captured once created, and are added to the step pods
+	// as host aliases. The services are keyed by name, so
+	// retried requests do not add duplicate host aliases.
+	exposed := map[string]*v1.Service{}
+	for _, service := range toServices(spec) {
+		service := service
+		objects = append(objects, &object{
+			kind: "service",
+			name: service.Name,
+			create: func() error {
+				created, err := services.Create(service)
+				if err == nil {
+					exposed[created.Name] = created
+				}
+				return err
+			},
+			get: func() (metav1.Object, error) {
+				found, err := services.Get(service.Name, metav1.GetOptions{})
+				if err == nil {
+					exposed[found.Name] = found
+				}
+				return found, err
+			},
+			delete: func() error {
+				return services.Delete(service.Name, &metav1.DeleteOptions{})
+			},
+			patch: func(data []byte) error {
+				_, err := services.Patch(service.Name, types.MergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	// the disruption budget is created before the pod, so
+	// the pod is protected from eviction once scheduled.
+	if budget := toPodDisruptionBudget(spec); budget != nil {
+		objects = append(objects, &object{
+			kind: "poddisruptionbudget",
+			name: budget.Name,
+			create: func() error {
+				_, err := budgets.Create(budget)
+				return err
+			},
+			get: func() (metav1.Object, error) {
+				return budgets.Get(budget.Name, metav1.GetOptions{})
+			},
+			delete: func() error {
+				return budgets.Delete(budget.Name, &metav1.DeleteOptions{})
+			},
+			patch: func(data []byte) error {
+				_, err := budgets.Patch(budget.Name, types.MergePatchType, data)
+				return err
+			},
+		})
+	}
+
+	var pod *v1.Pod
+	objects = append(objects, &object{
+		kind: "pod",
+		name: spec.PodSpec.Name,
+		create: func() (err error) {
+			pod, err = pods.Create(toPod(spec))
+			return err
+		},
+		get: func() (_ metav1.Object, err error) {
+			pod, err = pods.Get(spec.PodSpec.Name, metav1.GetOptions{})
+			return pod, err
+		},
+		delete: func() error {
+			return pods.Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+		},
+	})
+
+	// start watching the pod events before the pod is created
+	// to ensure no scheduling events are missed.
+	spec.events = watchEvents(k.client, spec.PodSpec.Namespace, spec.PodSpec.Name)
+
+	var created []*object
+	for _, obj := range objects {
+		// the service addresses are resolved from the pipeline
+		// pod if the services run in separate pods. The pod is
+		// created after the services.
+		if obj.kind == "pod" {
+			spec.aliases = toServiceAliases(spec, exposed)
+		}
+		if err := createObject(ctx, spec, obj); err != nil {
+			k.rollback(ctx, spec, created)
 			return err
 		}
+		created = append(created, obj)
 	}
 
-	_, err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Create(toSecret(spec))
+	// the secrets and claims are created before the pod, because
+	// they are referenced by the pod, and are patched afterwards
+	// so they are owned by the pod. This ensures the objects are
+	// garbage collected with the pod, even if Destroy is never
+	// called.
+	patch, err := toOwnerPatch(pod)
 	if err != nil {
+		k.rollback(ctx, spec, created)
 		return err
 	}
 
-	_, err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Create(toPod(spec))
-	if err != nil {
-		return err
+	// the step and service pods are owned by the pipeline pod,
+	// and are garbage collected with the pipeline pod.
+	owner := toOwnerReference(pod)
+	spec.owner = &owner
+
+	for _, obj := range created {
+		if obj.patch == nil {
+			continue
+		}
+		err := retryTransient(ctx, func() error {
+			return obj.patch(patch)
+		})
+		if err != nil {
+			k.rollback(ctx, spec, created)
+			return fmt.Errorf("cannot update %s %s: %w", obj.kind, obj.name, err)
+		}
 	}
 
 	return nil
 }
 
+// helper function deletes the objects created by Setup, in
+// reverse order, if the setup fails. The objects are deleted
+// even if the context is cancelled.
+func (k *Kubernetes) rollback(ctx context.Context, spec *Spec, created []*object) {
+	log := logger.FromContext(ctx)
+
+	spec.events.stop()
+	spec.events = nil
+
+	for i := len(created) - 1; i >= 0; i-- {
+		obj := created[i]
+		err := retryTransient(context.Background(), obj.delete)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.WithError(err).
+				WithField("kind", obj.kind).
+				WithField("name", obj.name).
+				Warnln("cannot delete object after setup failed")
+		}
+	}
+}
+
 // Destroy the pipeline environment.
 func (k *Kubernetes) Destroy(ctx context.Context, specv runtime.Spec) error {
-	// HACK: this timeout delays deleting the Pod to ensure
-	// there is enough time to stream the logs.
-	<-time.After(time.Second * 5)
-
 	spec := specv.(*Spec)
+
+	k, err := k.cluster(spec.Cluster)
+	if err != nil {
+		return err
+	}
+
+	if spec.informed {
+		defer k.informer().release(spec.PodSpec.Namespace)
+		spec.informed = false
+	}
+
+	// objects that are not found were never created, or were
+	// already deleted, for example, by a failed setup or by
+	// garbage collection, and are ignored.
 	var result error
 
+	spec.events.stop()
+
+	// wait for the log streams of the attached steps to
+	// reach EOF before the pod is deleted to ensure the
+	// logs are not truncated.
+	spec.logs.wait(false, drainTimeout)
+
+	// the service mesh proxies never exit on their own, and
+	// are stopped before the pods are deleted so the pods
+	// terminate without waiting for the proxies to drain.
+	// the pods are deleted even if the proxies cannot be
+	// stopped.
+	if hasMesh(spec) {
+		names := []string{spec.PodSpec.Name}
+		for _, step := range spec.Steps {
+			if isSeparatePod(spec, step) {
+				names = append(names, step.ID)
+			}
+		}
+		for _, name := range names {
+			err := k.quitProxy(spec, name)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				logger.FromContext(ctx).
+					WithError(err).
+					WithField("pod", name).
+					Debugln("cannot stop the mesh proxy")
+			}
+		}
+	}
+
+	err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		result = multierror.Append(result, err)
+	}
+
+	for _, step := range spec.Steps {
+		if !isSeparatePod(spec, step) {
+			continue
+		}
+		err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Delete(step.ID, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	// detached steps (services) run until the pod is
+	// deleted. wait for the remaining log streams to reach
+	// EOF as the containers terminate.
+	spec.logs.wait(true, drainTimeout)
+
 	if spec.PullSecret != nil {
 		err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(spec.PullSecret.Name, &metav1.DeleteOptions{})
-		if err != nil {
+		if err != nil && !k8serrors.IsNotFound(err) {
 			result = multierror.Append(result, err)
 		}
 	}
 
-	err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
-	if err != nil {
+	err = k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
 		result = multierror.Append(result, err)
 	}
 
-	err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
-	if err != nil {
-		result = multierror.Append(result, err)
+	for _, service := range toServices(spec) {
+		err := k.client.CoreV1().Services(spec.PodSpec.Namespace).Delete(service.Name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if spec.DisruptionBudget {
+		err := k.client.PolicyV1beta1().PodDisruptionBudgets(spec.PodSpec.Namespace).Delete(spec.PodSpec.Name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	for _, claim := range toPersistentVolumeClaims(spec) {
+		err := k.client.CoreV1().PersistentVolumeClaims(spec.PodSpec.Namespace).Delete(claim.Name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			result = multierror.Append(result, err)
+		}
 	}
 
 	if spec.Namespace != "" {
 		err := k.client.CoreV1().Namespaces().Delete(spec.Namespace, &metav1.DeleteOptions{})
-		if err != nil {
+		if err != nil && !k8serrors.IsNotFound(err) {
 			result = multierror.Append(result, err)
 		}
 	}
@@ -164,107 +510,216 @@ func (k *Kubernetes) Run(ctx context.Context, specv runtime.Spec, stepv runtime.
 	spec := specv.(*Spec)
 	step := stepv.(*Step)
 
-	err := k.start(spec, step)
+	k, err := k.cluster(spec.Cluster)
 	if err != nil {
-		// if ctx.Err() != nil {
-		// 	return nil, ctx.Err()
-		// }
 		return nil, err
 	}
 
+	// track the log stream for the duration of the step so
+	// the pipeline environment is not destroyed before the
+	// logs are fully streamed.
+	spec.logs.open(step)
+	defer spec.logs.close(step)
+
+	// if the step runs in its own pod, the events of the step
+	// pod are watched for the duration of the step.
+	events := spec.events
+	if isSeparatePod(spec, step) {
+		events = watchEvents(k.client, spec.PodSpec.Namespace, step.ID)
+		defer events.stop()
+	}
+
+	// the outputs of the step dependencies cannot be exposed
+	// to plugin steps in the pipeline pod, or if the output
+	// name is too long, and the step logs explain why the
+	// outputs are missing.
+	if isOutputDropped(spec, step) {
+		io.WriteString(output, "the outputs of the step dependencies are only available to plugins with multi_pod enabled\n")
+	}
+	for env, reason := range invalidOutputs(spec, step) {
+		fmt.Fprintf(output, "the output %s is not available to the step: %s\n", env, reason)
+	}
+
+	// write the pod events, such as image pulls, to the step
+	// logs until the step starts.
+	events.attach(step, output)
+
+	// the steps in the pipeline pod are not started until
+	// the service mesh proxy is ready, otherwise the steps
+	// may start without network access.
+	if !step.Init && !isSeparatePod(spec, step) {
+		if err := k.waitForProxy(ctx, spec); err != nil {
+			events.detach(step)
+			return nil, err
+		}
+	}
+
+	switch {
+	case isSeparatePod(spec, step):
+		// the step pod is created with the step image, and
+		// is not started by replacing the placeholder image.
+		err = k.createStepPod(ctx, spec, step)
+	case isShimStep(spec, step):
+		// the step container is created with the step image,
+		// and the shim starts the step once signalled.
+		err = k.signal(ctx, spec, step, shim.SignalStart)
+	case !step.Init:
+		// init steps run before the pod containers are started,
+		// and are not started by replacing the placeholder image.
+		err = k.start(spec, step)
+	}
+	if err != nil {
+		events.detach(step)
+		return nil, err
+	}
+
+	// if the pipeline is cancelled while the step is running,
+	// the step is actively stopped instead of running until
+	// the pod is deleted. The step is stopped once started,
+	// so the step is not started after it is stopped.
+	stop := k.killOnCancel(ctx, spec, step)
+	defer stop()
+
 	err = k.waitForReady(ctx, spec, step)
+	events.detach(step)
 	if err != nil {
 		return nil, err
 	}
 
+	// the resource usage of the step is sampled while the
+	// step runs.
+	sampler := k.sampleUsage(spec, step)
+	defer sampler.finish()
+
 	err = k.tail(ctx, spec, step, output)
-	// this feature flag retries fetching the logs if it fails on
-	// the first attempt. This is meant to help triage the following
-	// issue:
-	//
-	//    https://discourse.drone.io/t/kubernetes-runner-intermittently-fails-steps/7372
-	//
-	// BEGIN: FEATURE FLAG
 	if err != nil {
-		if os.Getenv("DRONE_FEATURE_FLAG_RETRY_LOGS") == "true" {
-			<-time.After(time.Second * 5)
-			err = k.tail(ctx, spec, step, output)
-		}
-		if err != nil {
-			<-time.After(time.Second * 5)
-			err = k.tail(ctx, spec, step, output)
-		}
+		return nil, err
 	}
-	// END: FEATURE FAG
+
+	state, err := k.waitForTerminated(ctx, spec, step)
 	if err != nil {
 		return nil, err
 	}
+	state.Usage = sampler.finish()
+	return state, nil
+}
 
-	return k.waitForTerminated(ctx, spec, step)
+// helper function returns the pod informer of the engine. The
+// informer is created on first use if the engine was not
+// created with a constructor.
+func (k *Kubernetes) informer() *podInformer {
+	k.once.Do(func() {
+		if k.pods == nil {
+			k.pods = newPodInformer(k.client)
+		}
+	})
+	return k.pods
 }
 
-func (k *Kubernetes) waitFor(ctx context.Context, spec *Spec, conditionFunc func(pod *v1.Pod) (bool, error)) error {
-	label := fmt.Sprintf("io.drone.name=%s", spec.PodSpec.Name)
-	lw := &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
-			options.LabelSelector = label
+func (k *Kubernetes) waitFor(ctx context.Context, namespace, name string, conditionFunc func(pod *v1.Pod) (bool, error)) error {
+	pods := k.informer()
+	pods.acquire(namespace)
+	defer pods.release(namespace)
 
-			return k.client.CoreV1().Pods(spec.PodSpec.Namespace).List(options)
-		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			options.LabelSelector = label
+	// the watcher is registered before the cache is read, so
+	// no changes are missed between reading the cache and
+	// waiting for notifications.
+	watcher := pods.watch(namespace, name)
+	defer pods.unwatch(namespace, name, watcher)
 
-			return k.client.CoreV1().Pods(spec.PodSpec.Namespace).Watch(options)
-		},
+	if err := pods.sync(ctx, namespace); err != nil {
+		return err
 	}
 
-	preconditionFunc := func(store cache.Store) (bool, error) {
-		obj, exists, err := store.Get(&metav1.ObjectMeta{Namespace: spec.PodSpec.Namespace, Name: spec.PodSpec.Name})
+	for {
+		pod, err := pods.get(namespace, name)
 		if err != nil {
-			return true, err
-		}
-		if !exists {
-			return true, fmt.Errorf("pod doesn't exist")
+			return err
 		}
-
-		pod, ok := obj.(*v1.Pod)
-		if !ok {
-			return true, fmt.Errorf("unexpected object type: %v", obj)
+		// the pod may be missing from the cache because it was
+		// deleted before the watcher was registered, or because
+		// the informer has not yet observed the created pod.
+		if pod == nil && !pods.deleted(watcher) {
+			_, err := k.client.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+			if k8serrors.IsNotFound(err) {
+				return fmt.Errorf("pod got deleted")
+			}
 		}
-
-		return conditionFunc(pod)
-	}
-
-	_, err := watchtools.UntilWithSync(ctx, lw, &v1.Pod{}, preconditionFunc, func(e watch.Event) (bool, error) {
-		switch t := e.Type; t {
-		case watch.Added, watch.Modified:
-			pod, ok := e.Object.(*v1.Pod)
-			if !ok || pod.ObjectMeta.Name != spec.PodSpec.Name {
-				return false, nil
+		switch {
+		case pods.deleted(watcher):
+			// if the pod was deleted because of a disruption,
+			// for example, a node drain, the disruption is
+			// reported so the stage can be retried.
+			if final := pods.final(watcher); final != nil {
+				if err := checkDisrupted(final); err != nil {
+					return err
+				}
+			}
+			return fmt.Errorf("pod got deleted")
+		case pod != nil && pod.Status.Reason == podReasonDeadlineExceeded:
+			return ErrDeadlineExceeded
+		case pod != nil:
+			if err := checkDisrupted(pod); err != nil {
+				return err
+			}
+			ok, err := conditionFunc(pod)
+			if err != nil || ok {
+				return err
 			}
+		}
 
-			return conditionFunc(pod)
-		case watch.Deleted:
-			return false, fmt.Errorf("pod got deleted")
-		default:
-			return false, nil
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-watcher.notify:
 		}
-	})
-	return err
+	}
 }
 
 func (k *Kubernetes) waitForReady(ctx context.Context, spec *Spec, step *Step) error {
-	return k.waitFor(ctx, spec, func(pod *v1.Pod) (bool, error) {
-		for _, cs := range pod.Status.ContainerStatuses {
+	ctxpending := ctx
+	if k.PendingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctxpending, cancel = context.WithTimeout(ctx, k.PendingTimeout)
+		defer cancel()
+	}
+
+	// pending stores the most recent reason the step
+	// container has not yet started, if known.
+	var pending *PodError
+
+	err := k.waitFor(ctxpending, spec.PodSpec.Namespace, podName(spec, step), func(pod *v1.Pod) (bool, error) {
+		for _, cs := range containerStatuses(pod, step) {
 			if cs.Name != step.ID {
 				continue
 			}
-			if !image.Match(cs.Image, step.Placeholder) && (cs.State.Running != nil || cs.State.Terminated != nil) {
+			if isStepImage(cs, step) && (cs.State.Running != nil || cs.State.Terminated != nil) {
 				return true, nil
 			}
 		}
+		// if an init step fails the pod fails, and the pod
+		// containers are never started.
+		if pod.Status.Phase == v1.PodFailed {
+			return false, errPodFailed
+		}
+		perr, fatal := checkPending(pod, step)
+		if fatal {
+			return false, perr
+		}
+		pending = perr
 		return false, nil
 	})
+
+	// if the pending timeout is exceeded, but the parent
+	// context is still active, the step is failed with the
+	// last known reason it was unable to start.
+	if err != nil && ctx.Err() == nil && ctxpending.Err() != nil {
+		if pending != nil {
+			return fmt.Errorf("step pending longer than %s: %w", k.PendingTimeout, pending)
+		}
+		return fmt.Errorf("step pending longer than %s", k.PendingTimeout)
+	}
+	return err
 }
 
 func (k *Kubernetes) waitForTerminated(ctx context.Context, spec *Spec, step *Step) (*runtime.State, error) {
@@ -272,13 +727,23 @@ func (k *Kubernetes) waitForTerminated(ctx context.Context, spec *Spec, step *St
 		Exited:    true,
 		OOMKilled: false,
 	}
-	err := k.waitFor(ctx, spec, func(pod *v1.Pod) (bool, error) {
-		for _, cs := range pod.Status.ContainerStatuses {
+	err := k.waitFor(ctx, spec.PodSpec.Namespace, podName(spec, step), func(pod *v1.Pod) (bool, error) {
+		for _, cs := range containerStatuses(pod, step) {
 			if cs.Name != step.ID {
 				continue
 			}
-			if !image.Match(cs.Image, step.Placeholder) && cs.State.Terminated != nil {
+			if isStepImage(cs, step) && cs.State.Terminated != nil {
 				state.ExitCode = int(cs.State.Terminated.ExitCode)
+				state.Reason = cs.State.Terminated.Reason
+				state.Message = cs.State.Terminated.Message
+				state.OOMKilled = cs.State.Terminated.Reason == "OOMKilled"
+				// the termination message is written by the step
+				// when the container exits normally, otherwise it
+				// describes why the container terminated.
+				switch state.Reason {
+				case "Completed", "Error":
+					state.Outputs, state.Summary = parseOutput(state.Message)
+				}
 				return true, nil
 			}
 		}
@@ -292,25 +757,57 @@ func (k *Kubernetes) waitForTerminated(ctx context.Context, spec *Spec, step *St
 }
 
 func (k *Kubernetes) tail(ctx context.Context, spec *Spec, step *Step, output io.Writer) error {
-	opts := &v1.PodLogOptions{
-		Follow:    true,
-		Container: step.ID,
-	}
-
-	req := k.client.CoreV1().RESTClient().Get().
-		Namespace(spec.PodSpec.Namespace).
-		Name(spec.PodSpec.Name).
-		Resource("pods").
-		SubResource("log").
-		VersionedParams(opts, scheme.ParameterCodec)
-
-	readCloser, err := req.Stream()
+	// the log stream is complete once it reaches EOF, or
+	// fails, even though the step may still be running.
+	defer spec.logs.close(step)
+
+	namespace, name := spec.PodSpec.Namespace, podName(spec, step)
+
+	// the log stream may not be immediately available after
+	// the container starts. opening the stream is retried
+	// with backoff, however, the stream itself is never
+	// retried to avoid duplicate log lines.
+	var readCloser io.ReadCloser
+	var streamErr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		readCloser, streamErr = k.logs.Stream(namespace, name, step.ID)
+		return streamErr == nil, nil
+	})
 	if err != nil {
+		if streamErr != nil {
+			return streamErr
+		}
 		return err
 	}
 	defer readCloser.Close()
 
-	return livelog.Copy(output, readCloser)
+	// the log stream cannot be cancelled, and only reaches
+	// EOF once the container exits. if the step is cancelled,
+	// the stream is closed after a short delay, so the step
+	// can write its final logs as it exits.
+	delay := streamCloseDelay
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+			readCloser.Close()
+		}
+	}()
+
+	err = livelog.Copy(output, readCloser)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
 
 func (k *Kubernetes) start(spec *Spec, step *Step) error {
@@ -332,8 +829,8 @@ func (k *Kubernetes) start(spec *Spec, step *Step) error {
 				if pod.ObjectMeta.Annotations == nil {
 					pod.ObjectMeta.Annotations = map[string]string{}
 				}
-				for _, env := range statusesWhiteList {
-					pod.ObjectMeta.Annotations[env] = step.Envs[env]
+				for k, v := range toStepAnnotations(step) {
+					pod.ObjectMeta.Annotations[k] = v
 				}
 			}
 		}
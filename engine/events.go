@@ -0,0 +1,227 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// maximum number of pod events that are buffered when no
+// step is waiting to start.
+const eventBufferSize = 25
+
+// eventStream watches the Kubernetes events involving the
+// pipeline pod, and writes a summary of each event to the
+// logs of the steps that are waiting to start. This helps
+// explain slow starts, such as scheduling delays or large
+// image pulls.
+type eventStream struct {
+	sync.Mutex
+
+	watcher watch.Interface
+	steps   map[string]*eventWriter
+	pulling map[string]time.Time
+	pending []string
+}
+
+type eventWriter struct {
+	step   *Step
+	output io.Writer
+}
+
+// helper function starts watching the events involving the
+// named pod. The watch should be started before the pod is
+// created to ensure no events are missed. Events are a best
+// effort mechanism; if the events cannot be watched, a nil
+// stream is returned.
+func watchEvents(client kubernetes.Interface, namespace, name string) *eventStream {
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": name,
+	}.AsSelector().String()
+
+	list, err := client.CoreV1().Events(namespace).List(metav1.ListOptions{
+		FieldSelector: selector,
+	})
+	if err != nil {
+		return nil
+	}
+
+	lw := &cache.ListWatch{
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return client.CoreV1().Events(namespace).Watch(options)
+		},
+	}
+	watcher, err := watchtools.NewRetryWatcher(list.ResourceVersion, lw)
+	if err != nil {
+		return nil
+	}
+
+	s := &eventStream{
+		watcher: watcher,
+		steps:   map[string]*eventWriter{},
+		pulling: map[string]time.Time{},
+	}
+	go func() {
+		for e := range watcher.ResultChan() {
+			if event, ok := e.Object.(*v1.Event); ok {
+				s.handle(event)
+			}
+		}
+	}()
+	return s
+}
+
+// stop stops watching events.
+func (s *eventStream) stop() {
+	if s != nil {
+		s.watcher.Stop()
+	}
+}
+
+// attach writes the events involving the step, and the pod,
+// to the writer until the step is detached.
+func (s *eventStream) attach(step *Step, output io.Writer) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.steps[step.ID] = &eventWriter{step: step, output: output}
+	for _, line := range s.pending {
+		io.WriteString(output, line)
+	}
+	s.pending = nil
+	s.Unlock()
+}
+
+// detach stops writing events to the step logs. It should be
+// invoked before the step starts writing its own output.
+func (s *eventStream) detach(step *Step) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	delete(s.steps, step.ID)
+	s.Unlock()
+}
+
+func (s *eventStream) handle(event *v1.Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	// events that do not reference a container, such as
+	// scheduling and volume events, apply to the pod and
+	// are written to all waiting steps.
+	container := containerName(event.InvolvedObject.FieldPath)
+	if container == "" {
+		line := formatEvent(event, 0)
+		if line == "" {
+			return
+		}
+		if len(s.steps) == 0 {
+			if len(s.pending) < eventBufferSize {
+				s.pending = append(s.pending, line)
+			}
+			return
+		}
+		for _, w := range s.steps {
+			io.WriteString(w.output, line)
+		}
+		return
+	}
+
+	var elapsed time.Duration
+	switch event.Reason {
+	case "Pulling":
+		s.pulling[container] = eventTime(event)
+	case "Pulled":
+		if started, ok := s.pulling[container]; ok {
+			elapsed = eventTime(event).Sub(started)
+			delete(s.pulling, container)
+		}
+	}
+
+	w, ok := s.steps[container]
+	if !ok {
+		return
+	}
+	// ignore events for the placeholder image, which is
+	// replaced by the step image when the step starts.
+	if w.step.Placeholder != "" && strings.Contains(event.Message, w.step.Placeholder) {
+		return
+	}
+	if line := formatEvent(event, elapsed); line != "" {
+		io.WriteString(w.output, line)
+	}
+}
+
+// helper function returns a concise, single line summary of
+// the event. An empty string is returned if the event should
+// not be written to the logs.
+func formatEvent(event *v1.Event, elapsed time.Duration) string {
+	switch event.Reason {
+	case "Scheduled":
+		if i := strings.LastIndex(event.Message, " to "); i != -1 {
+			return fmt.Sprintf("Scheduled on node %s\n", event.Message[i+4:])
+		}
+		return fmt.Sprintf("Scheduled: %s\n", event.Message)
+	case "Pulling":
+		return fmt.Sprintf("Pulling image %s\n", quotedImage(event.Message))
+	case "Pulled":
+		if elapsed > 0 {
+			return fmt.Sprintf("Pulled image %s in %s\n", quotedImage(event.Message), elapsed)
+		}
+		return event.Message + "\n"
+	case "Created", "Started", "Killing":
+		return ""
+	default:
+		return fmt.Sprintf("%s: %s\n", event.Reason, event.Message)
+	}
+}
+
+// helper function returns the container name from the event
+// field path (e.g. spec.containers{name}).
+func containerName(fieldPath string) string {
+	for _, prefix := range []string{"spec.containers{", "spec.initContainers{"} {
+		if strings.HasPrefix(fieldPath, prefix) {
+			return strings.TrimSuffix(strings.TrimPrefix(fieldPath, prefix), "}")
+		}
+	}
+	return ""
+}
+
+// helper function returns the quoted image name from the
+// event message (e.g. Pulling image "golang:1.15").
+func quotedImage(message string) string {
+	parts := strings.Split(message, `"`)
+	if len(parts) < 3 {
+		return message
+	}
+	return parts[1]
+}
+
+// helper function returns the time the event last occurred.
+func eventTime(event *v1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return time.Now()
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventStream(t *testing.T) {
+	now := time.Now()
+	step := &Step{ID: "step1", Placeholder: "drone/placeholder:1"}
+	stream := &eventStream{
+		steps:   map[string]*eventWriter{},
+		pulling: map[string]time.Time{},
+	}
+
+	// pod events received before the step is attached are
+	// buffered and written when the step is attached.
+	stream.handle(&v1.Event{
+		Reason:  "Scheduled",
+		Message: "Successfully assigned default/drone-test to node1",
+	})
+
+	var buf bytes.Buffer
+	stream.attach(step, &buf)
+
+	stream.handle(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{step1}"},
+		Reason:         "Pulling",
+		Message:        `Pulling image "drone/placeholder:1"`,
+		LastTimestamp:  metav1.NewTime(now),
+	})
+	stream.handle(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{step1}"},
+		Reason:         "Pulling",
+		Message:        `Pulling image "golang:1.15"`,
+		LastTimestamp:  metav1.NewTime(now),
+	})
+	stream.handle(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{step1}"},
+		Reason:         "Pulled",
+		Message:        `Successfully pulled image "golang:1.15"`,
+		LastTimestamp:  metav1.NewTime(now.Add(12 * time.Second)),
+	})
+	stream.handle(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{step2}"},
+		Reason:         "Pulling",
+		Message:        `Pulling image "node:14"`,
+		LastTimestamp:  metav1.NewTime(now),
+	})
+
+	stream.detach(step)
+	stream.handle(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{step1}"},
+		Reason:         "Started",
+		Message:        "Started container step1",
+	})
+
+	want := "Scheduled on node node1\nPulling image golang:1.15\nPulled image golang:1.15 in 12s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want logs %q, got %q", want, got)
+	}
+}
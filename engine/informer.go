@@ -0,0 +1,218 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podSelector selects the pods created by the runner.
+const podSelector = "io.drone=true"
+
+// podInformer maintains a shared cache of the pipeline pods
+// in each namespace with running pipelines, and notifies the
+// pipeline steps waiting for a pod to change. A single
+// informer is shared by all pipelines in a namespace to limit
+// the number of open watches against the Kubernetes API
+// server. The informers are scoped to the namespace, so the
+// runner does not require cluster-wide access to pods, and
+// are stopped once no pipelines are running in the namespace.
+type podInformer struct {
+	sync.Mutex
+
+	client     kubernetes.Interface
+	namespaces map[string]*namespaceInformer
+	watchers   map[string]map[*podWatcher]struct{}
+}
+
+// namespaceInformer is the shared informer of a namespace.
+type namespaceInformer struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+	refs     int
+}
+
+// podWatcher is notified when the watched pod changes.
+type podWatcher struct {
+	notify  chan struct{}
+	deleted bool
+
+	// final state of the pod when it was deleted, if known.
+	final *v1.Pod
+}
+
+func newPodInformer(client kubernetes.Interface) *podInformer {
+	return &podInformer{
+		client:     client,
+		namespaces: map[string]*namespaceInformer{},
+		watchers:   map[string]map[*podWatcher]struct{}{},
+	}
+}
+
+// acquire starts the shared informer of the namespace, if
+// not already started. The informer runs until every call to
+// acquire is paired with a call to release.
+func (p *podInformer) acquire(namespace string) {
+	p.Lock()
+	defer p.Unlock()
+	if n, ok := p.namespaces[namespace]; ok {
+		n.refs++
+		return
+	}
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+			options.LabelSelector = podSelector
+			return p.client.CoreV1().Pods(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = podSelector
+			return p.client.CoreV1().Pods(namespace).Watch(options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &v1.Pod{}, 0, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			p.dispatch(obj, false)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			p.dispatch(obj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			p.dispatch(obj, true)
+		},
+	})
+	n := &namespaceInformer{
+		informer: informer,
+		stop:     make(chan struct{}),
+		refs:     1,
+	}
+	p.namespaces[namespace] = n
+	go informer.Run(n.stop)
+}
+
+// release stops the shared informer of the namespace once
+// it is no longer in use.
+func (p *podInformer) release(namespace string) {
+	p.Lock()
+	defer p.Unlock()
+	n, ok := p.namespaces[namespace]
+	if !ok {
+		return
+	}
+	n.refs--
+	if n.refs <= 0 {
+		close(n.stop)
+		delete(p.namespaces, namespace)
+	}
+}
+
+// sync blocks until the cache of the namespace informer is
+// synchronized. The informer must be acquired.
+func (p *podInformer) sync(ctx context.Context, namespace string) error {
+	p.Lock()
+	n, ok := p.namespaces[namespace]
+	p.Unlock()
+	if !ok {
+		return fmt.Errorf("pod informer not started for namespace %s", namespace)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), n.informer.HasSynced) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// get returns the named pod from the cache. A nil pod is
+// returned if the pod does not exist in the cache.
+func (p *podInformer) get(namespace, name string) (*v1.Pod, error) {
+	p.Lock()
+	n, ok := p.namespaces[namespace]
+	p.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	obj, exists, err := n.informer.GetIndexer().GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return obj.(*v1.Pod), nil
+}
+
+// watch registers a watcher for the named pod.
+func (p *podInformer) watch(namespace, name string) *podWatcher {
+	key := namespace + "/" + name
+	w := &podWatcher{notify: make(chan struct{}, 1)}
+	p.Lock()
+	if p.watchers[key] == nil {
+		p.watchers[key] = map[*podWatcher]struct{}{}
+	}
+	p.watchers[key][w] = struct{}{}
+	p.Unlock()
+	return w
+}
+
+// unwatch unregisters the watcher for the named pod.
+func (p *podInformer) unwatch(namespace, name string, w *podWatcher) {
+	key := namespace + "/" + name
+	p.Lock()
+	delete(p.watchers[key], w)
+	if len(p.watchers[key]) == 0 {
+		delete(p.watchers, key)
+	}
+	p.Unlock()
+}
+
+// deleted returns true if the watched pod was deleted.
+func (p *podInformer) deleted(w *podWatcher) bool {
+	p.Lock()
+	v := w.deleted
+	p.Unlock()
+	return v
+}
+
+// final returns the final state of the watched pod when it
+// was deleted, or nil if unknown.
+func (p *podInformer) final(w *podWatcher) *v1.Pod {
+	p.Lock()
+	v := w.final
+	p.Unlock()
+	return v
+}
+
+// dispatch notifies the watchers that the pod changed. The
+// notification is non-blocking; watchers always read the
+// latest version of the pod from the cache.
+func (p *podInformer) dispatch(obj interface{}, deleted bool) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	// the final state of a deleted pod may be wrapped in a
+	// tombstone if the deletion was missed by the watch.
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, _ := obj.(*v1.Pod)
+	p.Lock()
+	for w := range p.watchers[key] {
+		if deleted {
+			w.deleted = true
+			w.final = pod
+		}
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	p.Unlock()
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// helper function returns a channel that is closed once the
+// informer watch is established. The fake clientset does not
+// deliver the changes made before the watch is established.
+func watchStarted(client *fake.Clientset) <-chan struct{} {
+	started := make(chan struct{})
+	var once sync.Once
+	client.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		gvr := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+		w, err := client.Tracker().Watch(gvr, action.GetNamespace())
+		once.Do(func() { close(started) })
+		return true, w, err
+	})
+	return started
+}
+
+func TestWaitFor(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{
+		client: client,
+		pods:   newPodInformer(client),
+	}
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-test",
+			Namespace: "default",
+		},
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+	}
+	if _, err := client.CoreV1().Pods("default").Create(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the pod is updated once the informer watch is
+	// established.
+	started := watchStarted(client)
+	go func() {
+		<-started
+		pod := pod.DeepCopy()
+		pod.Status.Phase = v1.PodRunning
+		client.CoreV1().Pods("default").Update(pod)
+	}()
+
+	err := engine.waitFor(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, func(pod *v1.Pod) (bool, error) {
+		return pod.Status.Phase == v1.PodRunning, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// This test verifies that the pod informer is created on first
+// use when the engine is not created with a constructor.
+func TestWaitFor_NoInformer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{client: client}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if _, err := client.CoreV1().Pods("default").Create(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := engine.waitFor(ctx, "default", "drone-test", func(pod *v1.Pod) (bool, error) {
+		return pod.Status.Phase == v1.PodRunning, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if engine.pods == nil {
+		t.Errorf("Expect pod informer created")
+	}
+}
+
+func TestWaitFor_Deleted(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{
+		client: client,
+		pods:   newPodInformer(client),
+	}
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-test",
+			Namespace: "default",
+		},
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+	}
+	if _, err := client.CoreV1().Pods("default").Create(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the pod is deleted once the informer watch is
+	// established.
+	started := watchStarted(client)
+	go func() {
+		<-started
+		client.CoreV1().Pods("default").Delete("drone-test", &metav1.DeleteOptions{})
+	}()
+
+	err := engine.waitFor(ctx, spec.PodSpec.Namespace, spec.PodSpec.Name, func(pod *v1.Pod) (bool, error) {
+		return false, nil
+	})
+	if err == nil || err.Error() != "pod got deleted" {
+		t.Errorf("Expect pod deleted error, got %v", err)
+	}
+}
+
+func TestWaitFor_Disrupted(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{
+		client: client,
+		pods:   newPodInformer(client),
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+		Status: v1.PodStatus{
+			Phase:  v1.PodFailed,
+			Reason: "Evicted",
+		},
+	}
+	if _, err := client.CoreV1().Pods("default").Create(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := engine.waitFor(ctx, "default", "drone-test", func(pod *v1.Pod) (bool, error) {
+		return false, nil
+	})
+	if _, ok := err.(*DisruptionError); !ok {
+		t.Errorf("Expect disruption error, got %v", err)
+	}
+}
+
+// This test verifies waitFor does not block if the pod was
+// deleted before the watcher was registered.
+func TestWaitFor_DeletedBeforeWatch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{
+		client: client,
+		pods:   newPodInformer(client),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := engine.waitFor(ctx, "default", "drone-test", func(pod *v1.Pod) (bool, error) {
+		return false, nil
+	})
+	if err == nil || err.Error() != "pod got deleted" {
+		t.Errorf("Expect pod deleted error, got %v", err)
+	}
+}
+
+func TestPodInformer_Release(t *testing.T) {
+	informer := newPodInformer(fake.NewSimpleClientset())
+	informer.acquire("default")
+	informer.acquire("default")
+
+	stop := informer.namespaces["default"].stop
+	informer.release("default")
+	select {
+	case <-stop:
+		t.Errorf("Expect informer running while in use")
+	default:
+	}
+
+	informer.release("default")
+	select {
+	case <-stop:
+	default:
+		t.Errorf("Expect informer stopped once released")
+	}
+	if len(informer.namespaces) != 0 {
+		t.Errorf("Expect informer removed once released")
+	}
+}
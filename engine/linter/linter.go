@@ -59,6 +59,15 @@ func (l *Linter) Lint(pm manifest.Resource, repo *drone.Repo) error {
 	if err := checkVolumes(pipeline, repo.Trusted); err != nil {
 		return err
 	}
+	if err := checkDebug(pipeline, repo.Trusted); err != nil {
+		return err
+	}
+	if err := checkCluster(pipeline, repo.Trusted); err != nil {
+		return err
+	}
+	if err := checkCache(pipeline); err != nil {
+		return err
+	}
 	if err := checkNamespace(pipeline.Metadata.Namespace, repo.Slug, l.patterns); err != nil {
 		return err
 	}
@@ -66,7 +75,10 @@ func (l *Linter) Lint(pm manifest.Resource, repo *drone.Repo) error {
 }
 
 func checkSteps(pipeline *resource.Pipeline, trusted bool) error {
-	steps := append(pipeline.Services, pipeline.Steps...)
+	var steps []*resource.Step
+	steps = append(steps, pipeline.Init...)
+	steps = append(steps, pipeline.Services...)
+	steps = append(steps, pipeline.Steps...)
 
 	names := map[string]struct{}{}
 	if !pipeline.Clone.Disable {
@@ -102,6 +114,21 @@ func checkStep(step *resource.Step, trusted bool) error {
 	if !trusted && step.Privileged {
 		return errors.New("linter: untrusted repositories cannot enable privileged mode")
 	}
+	for _, port := range step.Ports {
+		if port.Port <= 0 || port.Port > 65535 {
+			return fmt.Errorf("linter: invalid port: %d", port.Port)
+		}
+		switch strings.ToUpper(port.Protocol) {
+		case "", "TCP", "UDP", "SCTP":
+		default:
+			return fmt.Errorf("linter: invalid port protocol: %s", port.Protocol)
+		}
+	}
+	if step.Ready != nil {
+		if err := checkReady(step.Ready); err != nil {
+			return err
+		}
+	}
 	for _, mount := range step.Volumes {
 		switch mount.Name {
 		case "workspace", "_workspace", "_docker_socket", "_status":
@@ -114,7 +141,75 @@ func checkStep(step *resource.Step, trusted bool) error {
 	return nil
 }
 
+func checkReady(ready *resource.Ready) error {
+	var checks int
+	if ready.TCP != 0 {
+		checks++
+		if ready.TCP < 0 || ready.TCP > 65535 {
+			return fmt.Errorf("linter: invalid ready port: %d", ready.TCP)
+		}
+	}
+	if ready.HTTP != nil {
+		checks++
+		if ready.HTTP.Port <= 0 || ready.HTTP.Port > 65535 {
+			return fmt.Errorf("linter: invalid ready port: %d", ready.HTTP.Port)
+		}
+	}
+	if len(ready.Exec) != 0 {
+		checks++
+	}
+	if checks != 1 {
+		return errors.New("linter: ready must define one tcp, http or exec check")
+	}
+	if ready.Timeout < 0 {
+		return errors.New("linter: invalid ready timeout")
+	}
+	return nil
+}
+
+func checkDebug(pipeline *resource.Pipeline, trusted bool) error {
+	if !trusted && pipeline.Debug {
+		return errors.New("linter: untrusted repositories cannot enable debug mode")
+	}
+	return nil
+}
+
+func checkCluster(pipeline *resource.Pipeline, trusted bool) error {
+	if !trusted && pipeline.Cluster != "" {
+		return errors.New("linter: untrusted repositories cannot select the cluster")
+	}
+	return nil
+}
+
+func checkCache(pipeline *resource.Pipeline) error {
+	if pipeline.Cache == nil {
+		return nil
+	}
+	for _, p := range pipeline.Cache.Paths {
+		p = filepath.Clean(p)
+		if !filepath.IsAbs(p) || p == "/" {
+			return fmt.Errorf("linter: invalid cache path: %s", p)
+		}
+		if strings.HasPrefix(p, "/run/drone") {
+			return fmt.Errorf("linter: cannot mount cache at /run/drone")
+		}
+	}
+	return nil
+}
+
 func checkVolumes(pipeline *resource.Pipeline, trusted bool) error {
+	if volume := pipeline.Workspace.Volume; volume != nil && volume.EmptyDir != nil {
+		err := checkEmptyDirVolume(volume.EmptyDir, trusted)
+		if err != nil {
+			return err
+		}
+	}
+	// the workspace claim is provisioned with the storage class
+	// and size chosen by the repository. Untrusted repositories
+	// use the workspace claim configured by the runner.
+	if volume := pipeline.Workspace.Volume; volume != nil && volume.Claim != nil && !trusted {
+		return errors.New("linter: untrusted repositories cannot mount PVC")
+	}
 	for _, volume := range pipeline.Volumes {
 		if volume.EmptyDir != nil {
 			err := checkEmptyDirVolume(volume.EmptyDir, trusted)
@@ -32,6 +32,20 @@ func TestLint(t *testing.T) {
 			invalid: true,
 			message: "linter: invalid or missing image",
 		},
+		{
+			path:    "testdata/service_port_invalid.yml",
+			invalid: true,
+			message: "linter: invalid port: 70000",
+		},
+		{
+			path:    "testdata/service_ready.yml",
+			invalid: false,
+		},
+		{
+			path:    "testdata/service_ready_invalid.yml",
+			invalid: true,
+			message: "linter: ready must define one tcp, http or exec check",
+		},
 		// user should not use reserved volume names.
 		{
 			path:    "testdata/volume_missing_name.yml",
@@ -92,6 +106,28 @@ func TestLint(t *testing.T) {
 			trusted: true,
 			invalid: false,
 		},
+		{
+			path:    "testdata/workspace_memory.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot mount in-memory volumes",
+		},
+		{
+			path:    "testdata/workspace_memory.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/workspace_claim.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot mount PVC",
+		},
+		{
+			path:    "testdata/workspace_claim.yml",
+			trusted: true,
+			invalid: false,
+		},
 		// user should not be trying to mount internal or restricted
 		// volume paths.
 		{
@@ -113,6 +149,39 @@ func TestLint(t *testing.T) {
 			trusted: true,
 			invalid: false,
 		},
+		// user should not be able to enable debug mode
+		// unless the repository is trusted.
+		{
+			path:    "testdata/pipeline_debug.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot enable debug mode",
+		},
+		{
+			path:    "testdata/pipeline_debug.yml",
+			trusted: true,
+			invalid: false,
+		},
+		// user should not be able to select the cluster
+		// unless the repository is trusted.
+		{
+			path:    "testdata/pipeline_cluster.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot select the cluster",
+		},
+		{
+			path:    "testdata/pipeline_cluster.yml",
+			trusted: true,
+			invalid: false,
+		},
+		// cache paths must be absolute paths.
+		{
+			path:    "testdata/pipeline_cache.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: invalid cache path: go/pkg/mod",
+		},
 		// linter should verify whether or not a repository can
 		// use a target namespace
 		{
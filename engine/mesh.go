@@ -0,0 +1,129 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errProxyExited is returned when the service mesh proxy
+// exits before it is ready.
+var errProxyExited = errors.New("the mesh proxy exited before it was ready")
+
+// helper function returns true if the service mesh proxy is
+// configured for the pipeline.
+func hasMesh(spec *Spec) bool {
+	return spec.Mesh != nil && spec.Mesh.Proxy != ""
+}
+
+// helper function returns true if the named container was
+// injected into the pod. Proxies injected as native sidecars
+// are listed with the init containers.
+func hasContainer(pod *v1.Pod, name string) bool {
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == name {
+			return true
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the service mesh proxy
+// is ready, or if the proxy was not injected into the pod,
+// for example, because the pod or namespace opts out of
+// injection.
+func checkProxy(pod *v1.Pod, proxy string) (bool, error) {
+	if !hasContainer(pod, proxy) {
+		return true, nil
+	}
+	// native sidecars are restarted by the kubelet if they
+	// exit, and are only checked for readiness.
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.Name == proxy {
+			return cs.Ready, nil
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != proxy {
+			continue
+		}
+		if cs.State.Terminated != nil {
+			return false, errProxyExited
+		}
+		return cs.Ready, nil
+	}
+	if pod.Status.Phase == v1.PodFailed {
+		return false, errPodFailed
+	}
+	return false, nil
+}
+
+// waitForProxy blocks until the service mesh proxy injected
+// into the pipeline pod is ready, so the steps do not start
+// before the pod has network access. The proxy is awaited
+// once, and the result is shared by all steps.
+func (k *Kubernetes) waitForProxy(ctx context.Context, spec *Spec) error {
+	if !hasMesh(spec) {
+		return nil
+	}
+	spec.proxyOnce.Do(func() {
+		spec.proxyErr = k.waitForProxyReady(ctx, spec)
+	})
+	return spec.proxyErr
+}
+
+func (k *Kubernetes) waitForProxyReady(ctx context.Context, spec *Spec) error {
+	ctxpending := ctx
+	if k.PendingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctxpending, cancel = context.WithTimeout(ctx, k.PendingTimeout)
+		defer cancel()
+	}
+
+	err := k.waitFor(ctxpending, spec.PodSpec.Namespace, spec.PodSpec.Name, func(pod *v1.Pod) (bool, error) {
+		return checkProxy(pod, spec.Mesh.Proxy)
+	})
+
+	// if the pending timeout is exceeded, but the parent
+	// context is still active, the proxy is not ready.
+	if err != nil && ctx.Err() == nil && ctxpending.Err() != nil {
+		return fmt.Errorf("mesh proxy pending longer than %s", k.PendingTimeout)
+	}
+	return err
+}
+
+// quitProxy stops the service mesh proxy injected into the
+// named pod, using the proxy quit endpoint. The endpoint is
+// invoked through the api server pod proxy.
+func (k *Kubernetes) quitProxy(spec *Spec, name string) error {
+	if !hasMesh(spec) || spec.Mesh.QuitPort == 0 {
+		return nil
+	}
+	pod, err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !hasContainer(pod, spec.Mesh.Proxy) || pod.Status.Phase != v1.PodRunning {
+		return nil
+	}
+	return k.client.CoreV1().RESTClient().Post().
+		Namespace(spec.PodSpec.Namespace).
+		Resource("pods").
+		Name(fmt.Sprintf("%s:%d", name, spec.Mesh.QuitPort)).
+		SubResource("proxy").
+		Suffix(spec.Mesh.QuitPath).
+		Do().
+		Error()
+}
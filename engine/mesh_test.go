@@ -0,0 +1,148 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// helper function returns a pod with the step container and
+// the injected proxy container.
+func toProxyPod(proxy v1.ContainerStatus) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "drone-step"},
+				{Name: "istio-proxy"},
+			},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "drone-step"},
+				proxy,
+			},
+		},
+	}
+}
+
+func TestCheckProxy(t *testing.T) {
+	ok, err := checkProxy(toProxyPod(v1.ContainerStatus{Name: "istio-proxy"}), "istio-proxy")
+	if ok || err != nil {
+		t.Errorf("Expect proxy not ready, got %v, %v", ok, err)
+	}
+
+	ok, err = checkProxy(toProxyPod(v1.ContainerStatus{Name: "istio-proxy", Ready: true}), "istio-proxy")
+	if !ok || err != nil {
+		t.Errorf("Expect proxy ready, got %v, %v", ok, err)
+	}
+}
+
+func TestCheckProxy_NotInjected(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "drone-step"}},
+		},
+	}
+	ok, err := checkProxy(pod, "istio-proxy")
+	if !ok || err != nil {
+		t.Errorf("Expect pods without a proxy ignored, got %v, %v", ok, err)
+	}
+}
+
+func TestCheckProxy_Exited(t *testing.T) {
+	pod := toProxyPod(v1.ContainerStatus{
+		Name: "istio-proxy",
+		State: v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{ExitCode: 1},
+		},
+	})
+	_, err := checkProxy(pod, "istio-proxy")
+	if err != errProxyExited {
+		t.Errorf("Expect proxy exited error, got %v", err)
+	}
+}
+
+func TestCheckProxy_NativeSidecar(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "linkerd-proxy"}},
+			Containers:     []v1.Container{{Name: "drone-step"}},
+		},
+		Status: v1.PodStatus{
+			InitContainerStatuses: []v1.ContainerStatus{
+				{Name: "linkerd-proxy", Ready: true},
+			},
+		},
+	}
+	ok, err := checkProxy(pod, "linkerd-proxy")
+	if !ok || err != nil {
+		t.Errorf("Expect native sidecar proxy ready, got %v, %v", ok, err)
+	}
+}
+
+func TestWaitForProxy(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{
+		client: client,
+		pods:   newPodInformer(client),
+	}
+
+	pod := toProxyPod(v1.ContainerStatus{Name: "istio-proxy", Ready: true})
+	if _, err := client.CoreV1().Pods("default").Create(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-test", Namespace: "default"},
+		Mesh:    &Mesh{Proxy: "istio-proxy"},
+	}
+	if err := engine.waitForProxy(ctx, spec); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWaitForProxy_Disabled(t *testing.T) {
+	engine := &Kubernetes{}
+	if err := engine.waitForProxy(context.Background(), &Spec{}); err != nil {
+		t.Errorf("Expect no wait without a mesh proxy, got %v", err)
+	}
+}
+
+func TestQuitProxy_NotInjected(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "drone-step"}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	})
+	engine := &Kubernetes{client: client}
+
+	spec := &Spec{
+		PodSpec: PodSpec{Name: "drone-test", Namespace: "default"},
+		Mesh:    &Mesh{Proxy: "istio-proxy", QuitPort: 15020, QuitPath: "/quitquitquit"},
+	}
+	if err := engine.quitProxy(spec, "drone-test"); err != nil {
+		t.Errorf("Expect pods without a proxy ignored, got %v", err)
+	}
+}
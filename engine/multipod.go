@@ -0,0 +1,233 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// name of the container that keeps the pipeline pod running
+// in multi-pod mode, if the pipeline has no services.
+const pipelineContainerName = "pipeline"
+
+// helper function returns true if the step runs in its own
+// pod. In multi-pod mode, the services, init steps and the
+// debug container run in the pipeline pod, and all other
+// steps run in separate pods.
+func isStepPod(spec *Spec, step *Step) bool {
+	if !spec.MultiPod || step.Init || step.Detach {
+		return false
+	}
+	if spec.Debug != nil && spec.Debug.Step != nil && spec.Debug.Step.ID == step.ID {
+		return false
+	}
+	return true
+}
+
+// helper function returns true if the service runs in its
+// own pod, and is exposed to the pipeline with a Kubernetes
+// service, so that services listening on the same port do
+// not collide.
+func isServicePod(spec *Spec, step *Step) bool {
+	if !spec.ServicePods || step.Init || !step.Detach {
+		return false
+	}
+	if spec.Debug != nil && spec.Debug.Step != nil && spec.Debug.Step.ID == step.ID {
+		return false
+	}
+	return true
+}
+
+// helper function returns true if the step runs in its own
+// pod, instead of the pipeline pod.
+func isSeparatePod(spec *Spec, step *Step) bool {
+	return isStepPod(spec, step) || isServicePod(spec, step)
+}
+
+// helper function returns the name of the pod the step
+// runs in.
+func podName(spec *Spec, step *Step) string {
+	if isSeparatePod(spec, step) {
+		return step.ID
+	}
+	return spec.PodSpec.Name
+}
+
+// helper function returns the pod that runs the step, or
+// the service, in its own pod. The pod is created with the step image,
+// and with the current step environment, so the placeholder
+// image is not used. The pod is owned by the pipeline pod.
+func toStepPod(spec *Spec, step *Step) *v1.Pod {
+	container := toContainer(spec, step)
+	container.Image = step.Image
+
+	// the pipeline pod name label is replaced, so the step
+	// pods are not selected by the service selectors of the
+	// pipeline pod.
+	labels := map[string]string{}
+	for k, v := range spec.PodSpec.Labels {
+		labels[k] = v
+	}
+	labels["io.drone.name"] = step.ID
+
+	annotations := map[string]string{}
+	for k, v := range spec.PodSpec.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range toStepAnnotations(step) {
+		annotations[k] = v
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        step.ID,
+			Namespace:   spec.PodSpec.Namespace,
+			Annotations: annotations,
+			Labels:      labels,
+		},
+		Spec: v1.PodSpec{
+			ServiceAccountName: spec.PodSpec.ServiceAccountName,
+			RestartPolicy:      v1.RestartPolicyNever,
+			Volumes:            toVolumes(spec),
+			Containers:         []v1.Container{container},
+			NodeName:           spec.PodSpec.NodeName,
+			NodeSelector:       spec.PodSpec.NodeSelector,
+			Tolerations:        toTolerations(spec),
+			ImagePullSecrets:   toImagePullSecrets(spec),
+			HostAliases:        toHostAliases(spec.aliases),
+			DNSConfig:          toDnsConfig(spec),
+
+			ActiveDeadlineSeconds:         toActiveDeadline(spec),
+			TerminationGracePeriodSeconds: toGracePeriodSeconds(spec),
+		},
+	}
+	if spec.owner != nil {
+		pod.OwnerReferences = []metav1.OwnerReference{*spec.owner}
+	}
+	return pod
+}
+
+// helper function returns the container that keeps the
+// pipeline pod running in multi-pod mode.
+func toPipelineContainer(spec *Spec) v1.Container {
+	var image string
+	for _, step := range spec.Steps {
+		if step.Placeholder != "" {
+			image = step.Placeholder
+			break
+		}
+	}
+	return v1.Container{
+		Name:  pipelineContainerName,
+		Image: image,
+	}
+}
+
+// helper function returns the Kubernetes services that
+// expose the pipeline services to the pods that do not run
+// the service. Services without ports, or with names that
+// are not valid host names, are not exposed.
+func toServices(spec *Spec) []*v1.Service {
+	if !spec.MultiPod && !spec.ServicePods {
+		return nil
+	}
+	var services []*v1.Service
+	for _, step := range spec.Steps {
+		if !step.Detach || len(step.Ports) == 0 {
+			continue
+		}
+		if len(validation.IsDNS1123Subdomain(step.Name)) != 0 {
+			continue
+		}
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        serviceName(spec, step),
+				Labels:      spec.PodSpec.Labels,
+				Annotations: spec.PodSpec.Annotations,
+			},
+			Spec: v1.ServiceSpec{
+				Type: v1.ServiceTypeClusterIP,
+				// the pod is not ready until all containers are
+				// ready, and the service readiness is checked by
+				// the engine instead.
+				PublishNotReadyAddresses: true,
+				Selector: map[string]string{
+					"io.drone.name": podName(spec, step),
+				},
+			},
+		}
+		for _, port := range step.Ports {
+			protocol := v1.ProtocolTCP
+			if port.Protocol != "" {
+				protocol = v1.Protocol(strings.ToUpper(port.Protocol))
+			}
+			service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{
+				Name:       fmt.Sprintf("%s-%d", strings.ToLower(string(protocol)), port.Port),
+				Protocol:   protocol,
+				Port:       int32(port.Port),
+				TargetPort: intstr.FromInt(port.Port),
+			})
+		}
+		services = append(services, service)
+	}
+	return services
+}
+
+// helper function returns the name of the Kubernetes service
+// that exposes the pipeline service. If the pipeline runs in
+// its own namespace, the Kubernetes service is named after the
+// pipeline service, so the name is resolved by the cluster
+// dns. In a shared namespace the name is suffixed with the
+// pipeline pod name, so it is unique, and the pipeline service
+// name is only resolved with host aliases.
+func serviceName(spec *Spec, step *Step) string {
+	if spec.Namespace != "" && len(validation.IsDNS1035Label(step.Name)) == 0 {
+		return step.Name
+	}
+	name := step.Name + "-" + strings.TrimPrefix(spec.PodSpec.Name, "drone-")
+	if len(validation.IsDNS1035Label(name)) == 0 {
+		return name
+	}
+	return step.ID
+}
+
+// helper function returns the host aliases that resolve the
+// service names to the Kubernetes service addresses.
+func toServiceAliases(spec *Spec, services map[string]*v1.Service) []HostAlias {
+	var aliases []HostAlias
+	for _, step := range spec.Steps {
+		service, ok := services[serviceName(spec, step)]
+		if ok && service.Spec.ClusterIP != "" {
+			aliases = append(aliases, HostAlias{
+				IP:        service.Spec.ClusterIP,
+				Hostnames: []string{step.Name},
+			})
+		}
+	}
+	return aliases
+}
+
+// helper function creates the step, or service, pod. The
+// pod name is unique to the step, so a pod that already
+// exists was created by a previous attempt.
+func (k *Kubernetes) createStepPod(ctx context.Context, spec *Spec, step *Step) error {
+	pod := toStepPod(spec, step)
+	err := retryTransient(ctx, func() error {
+		_, err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Create(pod)
+		return err
+	})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsStepPod(t *testing.T) {
+	debug := &Step{ID: "debug"}
+	spec := &Spec{
+		MultiPod: true,
+		Debug:    &Debug{Step: debug},
+	}
+	tests := []struct {
+		step *Step
+		want bool
+	}{
+		{step: &Step{ID: "build"}, want: true},
+		{step: &Step{ID: "redis", Detach: true}, want: false},
+		{step: &Step{ID: "clone", Init: true}, want: false},
+		{step: debug, want: false},
+	}
+	for _, test := range tests {
+		if got := isStepPod(spec, test.step); got != test.want {
+			t.Errorf("Want step pod %v for step %s, got %v", test.want, test.step.ID, got)
+		}
+	}
+
+	spec.MultiPod = false
+	if isStepPod(spec, &Step{ID: "build"}) {
+		t.Errorf("Want no step pods unless multi-pod mode is enabled")
+	}
+}
+
+func TestToStepPod(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone.name": "drone-test", "io.drone": "true"},
+		},
+		MultiPod: true,
+		owner:    &metav1.OwnerReference{Kind: "Pod", Name: "drone-test"},
+		aliases:  []HostAlias{{IP: "10.0.0.1", Hostnames: []string{"redis"}}},
+	}
+	step := &Step{
+		ID:          "drone-build",
+		Name:        "build",
+		Image:       "golang:1",
+		Placeholder: "drone/placeholder:1",
+		Envs:        map[string]string{"DRONE_BUILD_STATUS": "success", "DRONE_OUTPUT_CLONE_TAG": "v1"},
+	}
+	spec.Steps = []*Step{step}
+
+	pod := toStepPod(spec, step)
+	if got, want := pod.Name, "drone-build"; got != want {
+		t.Errorf("Want pod name %s, got %s", want, got)
+	}
+	if got, want := pod.Labels["io.drone.name"], "drone-build"; got != want {
+		t.Errorf("Want pod name label %s, got %s", want, got)
+	}
+	if got, want := pod.Labels["io.drone"], "true"; got != want {
+		t.Errorf("Want pipeline label %s, got %s", want, got)
+	}
+	if spec.PodSpec.Labels["io.drone.name"] != "drone-test" {
+		t.Errorf("Want pipeline labels unchanged")
+	}
+	if got, want := pod.Annotations["DRONE_BUILD_STATUS"], "success"; got != want {
+		t.Errorf("Want status annotation %s, got %s", want, got)
+	}
+	if got, want := pod.Spec.Containers[0].Image, "golang:1"; got != want {
+		t.Errorf("Want step image %s, got %s", want, got)
+	}
+	// the dependency outputs are set in the step environment,
+	// so they are available to plugins.
+	var output string
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Name == "DRONE_OUTPUT_CLONE_TAG" {
+			output = env.Value
+		}
+	}
+	if got, want := output, "v1"; got != want {
+		t.Errorf("Want output environment variable %s, got %s", want, got)
+	}
+	if len(pod.OwnerReferences) != 1 || pod.OwnerReferences[0].Name != "drone-test" {
+		t.Errorf("Want step pod owned by the pipeline pod")
+	}
+	if len(pod.Spec.HostAliases) != 1 || pod.Spec.HostAliases[0].IP != "10.0.0.1" {
+		t.Errorf("Want service host aliases")
+	}
+
+	// the step runs in the step pod, and the pipeline pod
+	// is kept running with a placeholder container.
+	containers := toPod(spec).Spec.Containers
+	if len(containers) != 1 || containers[0].Name != pipelineContainerName {
+		t.Errorf("Want pipeline container in the pipeline pod")
+	}
+}
+
+func TestToServices(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name: "drone-test",
+		},
+		MultiPod: true,
+		Steps: []*Step{
+			{ID: "drone-build", Name: "build"},
+			{ID: "drone-redis", Name: "redis", Detach: true, Ports: []Port{{Port: 6379}, {Port: 53, Protocol: "udp"}}},
+			{ID: "drone-cache", Name: "cache", Detach: true},
+			{ID: "drone-invalid", Name: "Invalid_Name", Detach: true, Ports: []Port{{Port: 80}}},
+		},
+	}
+	services := toServices(spec)
+	if len(services) != 1 {
+		t.Fatalf("Want 1 service, got %d", len(services))
+	}
+	service := services[0]
+	// in a shared namespace, the service name is suffixed with
+	// the pipeline pod name.
+	if got, want := service.Name, "redis-test"; got != want {
+		t.Errorf("Want service name %s, got %s", want, got)
+	}
+	if got, want := len(service.Spec.Ports), 2; got != want {
+		t.Fatalf("Want %d service ports, got %d", want, got)
+	}
+	if got, want := service.Spec.Ports[0].Protocol, v1.ProtocolTCP; got != want {
+		t.Errorf("Want protocol %s, got %s", want, got)
+	}
+	if got, want := service.Spec.Ports[1].Protocol, v1.ProtocolUDP; got != want {
+		t.Errorf("Want protocol %s, got %s", want, got)
+	}
+	if got, want := service.Spec.Ports[1].Name, "udp-53"; got != want {
+		t.Errorf("Want port name %s, got %s", want, got)
+	}
+
+	spec.MultiPod = false
+	if len(toServices(spec)) != 0 {
+		t.Errorf("Want no services unless multi-pod mode is enabled")
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-test"}}
+	redis := &Step{ID: "drone-redis", Name: "redis"}
+	invalid := &Step{ID: "drone-invalid", Name: "redis.cache"}
+
+	if got, want := serviceName(spec, redis), "redis-test"; got != want {
+		t.Errorf("Want service name %s, got %s", want, got)
+	}
+	if got, want := serviceName(spec, invalid), "drone-invalid"; got != want {
+		t.Errorf("Want step id if the service name is invalid, got %s", got)
+	}
+
+	// in the pipeline namespace, the service is named after
+	// the pipeline service, so the name is resolved by dns.
+	spec.Namespace = "drone-namespace"
+	if got, want := serviceName(spec, redis), "redis"; got != want {
+		t.Errorf("Want service name %s, got %s", want, got)
+	}
+}
+
+func TestToServiceAliases(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "drone-build", Name: "build"},
+			{ID: "drone-redis", Name: "redis", Detach: true},
+			{ID: "drone-mysql", Name: "mysql", Detach: true},
+		},
+	}
+	services := map[string]*v1.Service{
+		"drone-redis": {Spec: v1.ServiceSpec{ClusterIP: "10.0.0.1"}},
+		"drone-mysql": {Spec: v1.ServiceSpec{ClusterIP: "10.0.0.2"}},
+	}
+	aliases := toServiceAliases(spec, services)
+	if len(aliases) != 2 {
+		t.Fatalf("Want 2 host aliases, got %d", len(aliases))
+	}
+	if got, want := aliases[0].IP, "10.0.0.1"; got != want {
+		t.Errorf("Want host alias ip %s, got %s", want, got)
+	}
+	if got, want := aliases[1].Hostnames[0], "mysql"; got != want {
+		t.Errorf("Want host alias hostname %s, got %s", want, got)
+	}
+}
+
+func TestIsServicePod(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name: "drone-test",
+		},
+		ServicePods: true,
+		Steps: []*Step{
+			{ID: "drone-build", Name: "build"},
+			{ID: "drone-redis", Name: "redis", Detach: true, Ports: []Port{{Port: 6379}}},
+		},
+	}
+	build, redis := spec.Steps[0], spec.Steps[1]
+	if isServicePod(spec, build) {
+		t.Errorf("Want step run in the pipeline pod")
+	}
+	if !isServicePod(spec, redis) {
+		t.Errorf("Want service run in a separate pod")
+	}
+	if got, want := podName(spec, redis), "drone-redis"; got != want {
+		t.Errorf("Want service pod name %s, got %s", want, got)
+	}
+	if got, want := podName(spec, build), "drone-test"; got != want {
+		t.Errorf("Want step pod name %s, got %s", want, got)
+	}
+
+	// the kubernetes service selects the service pod.
+	services := toServices(spec)
+	if len(services) != 1 {
+		t.Fatalf("Want 1 service, got %d", len(services))
+	}
+	if got, want := services[0].Spec.Selector["io.drone.name"], "drone-redis"; got != want {
+		t.Errorf("Want service selector %s, got %s", want, got)
+	}
+
+	// the service names are resolved to the service
+	// addresses from the pipeline pod.
+	spec.aliases = []HostAlias{{IP: "10.0.0.1", Hostnames: []string{"redis"}}}
+	pod := toPod(spec)
+	if len(pod.Spec.HostAliases) != 1 || pod.Spec.HostAliases[0].IP != "10.0.0.1" {
+		t.Errorf("Want service host aliases in the pipeline pod, got %v", pod.Spec.HostAliases)
+	}
+	if got, want := len(pod.Spec.Containers), 1; got != want {
+		t.Errorf("Want %d containers in the pipeline pod, got %d", want, got)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// OutputPath is the path of the file steps write outputs and
+// summaries to. The file is the container termination message
+// path, and the file contents are limited to 4096 bytes by
+// Kubernetes.
+const OutputPath = "/dev/termination-log"
+
+// outputEnvPrefix is the prefix of the environment variables
+// that expose the outputs of the step dependencies.
+const outputEnvPrefix = "DRONE_OUTPUT_"
+
+// helper function returns true if the environment variable
+// exposes a dependency output, and the variable name is a
+// valid pod annotation key.
+func isOutputEnv(name string) bool {
+	return strings.HasPrefix(name, outputEnvPrefix) &&
+		len(validation.IsQualifiedName(name)) == 0
+}
+
+// helper function returns true if the step depends on steps
+// that wrote outputs, but the outputs cannot be exposed to the
+// step. The outputs are exported by the step script, or are
+// set in the environment of the step pod, but steps without
+// a script that run in the pipeline pod, such as plugins,
+// cannot receive them.
+func isOutputDropped(spec *Spec, step *Step) bool {
+	if _, ok := step.Envs["DRONE_SCRIPT"]; ok || isSeparatePod(spec, step) {
+		return false
+	}
+	for env := range step.Envs {
+		if strings.HasPrefix(env, outputEnvPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns the dependency outputs that cannot
+// be exposed to the step script in the pipeline pod, because
+// the environment variable name is not a valid annotation key,
+// with the reason. The outputs set in the environment of the
+// step pod are not affected.
+func invalidOutputs(spec *Spec, step *Step) map[string]string {
+	if isSeparatePod(spec, step) {
+		return nil
+	}
+	invalid := map[string]string{}
+	for env := range step.Envs {
+		if !strings.HasPrefix(env, outputEnvPrefix) {
+			continue
+		}
+		if errs := validation.IsQualifiedName(env); len(errs) != 0 {
+			invalid[env] = strings.Join(errs, ", ")
+		}
+	}
+	return invalid
+}
+
+// helper function parses the step outputs and summary from
+// the container termination message. If the message is a
+// json object, the summary key is used as the markdown
+// summary, and the remaining keys are used as the outputs.
+// Otherwise the message is used as the markdown summary.
+func parseOutput(message string) (outputs map[string]string, summary string) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, ""
+	}
+	if !strings.HasPrefix(message, "{") {
+		return nil, message
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(message), &values); err != nil {
+		return nil, message
+	}
+	for k, v := range values {
+		var value string
+		switch v := v.(type) {
+		case string:
+			value = v
+		case float64, bool:
+			value = fmt.Sprint(v)
+		default:
+			continue
+		}
+		if k == "summary" {
+			summary = value
+			continue
+		}
+		if outputs == nil {
+			outputs = map[string]string{}
+		}
+		outputs[k] = value
+	}
+	return outputs, summary
+}
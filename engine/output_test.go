@@ -0,0 +1,107 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		message string
+		outputs map[string]string
+		summary string
+	}{
+		{
+			message: "",
+		},
+		{
+			message: "## Coverage\n\n- 82%",
+			summary: "## Coverage\n\n- 82%",
+		},
+		{
+			message: `{"version": "1.2.3", "count": 3, "ok": true, "summary": "## Release"}`,
+			outputs: map[string]string{"version": "1.2.3", "count": "3", "ok": "true"},
+			summary: "## Release",
+		},
+		{
+			message: `{"nested": {"key": "value"}, "list": [1, 2]}`,
+		},
+		{
+			message: `{not json}`,
+			summary: `{not json}`,
+		},
+	}
+	for _, test := range tests {
+		outputs, summary := parseOutput(test.message)
+		if diff := cmp.Diff(test.outputs, outputs); diff != "" {
+			t.Errorf("Unexpected outputs for %q", test.message)
+			t.Log(diff)
+		}
+		if got, want := summary, test.summary; got != want {
+			t.Errorf("Want summary %q, got %q", want, got)
+		}
+	}
+}
+
+func TestIsOutputEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"DRONE_OUTPUT_BUILD_VERSION", true},
+		{"DRONE_OUTPUT", false},
+		{"DRONE_BUILD_STATUS", false},
+		{"DRONE_OUTPUT_" + string(make([]byte, 64)), false},
+	}
+	for _, test := range tests {
+		if got := isOutputEnv(test.name); got != test.want {
+			t.Errorf("Want %v for %q, got %v", test.want, test.name, got)
+		}
+	}
+}
+
+func TestIsOutputDropped(t *testing.T) {
+	outputs := map[string]string{"DRONE_OUTPUT": OutputPath, "DRONE_OUTPUT_BUILD_VERSION": "1.2.3"}
+	tests := []struct {
+		spec *Spec
+		step *Step
+		want bool
+	}{
+		// plugin steps in the pipeline pod cannot receive outputs.
+		{&Spec{}, &Step{Envs: outputs}, true},
+		// plugin steps without dependency outputs.
+		{&Spec{}, &Step{Envs: map[string]string{"DRONE_OUTPUT": OutputPath}}, false},
+		// the step script exports the outputs.
+		{&Spec{}, &Step{Envs: map[string]string{"DRONE_SCRIPT": "", "DRONE_OUTPUT_BUILD_VERSION": "1.2.3"}}, false},
+		// the outputs are set in the step pod environment.
+		{&Spec{MultiPod: true}, &Step{Envs: outputs}, false},
+	}
+	for i, test := range tests {
+		if got := isOutputDropped(test.spec, test.step); got != test.want {
+			t.Errorf("Want output dropped %v at index %d, got %v", test.want, i, got)
+		}
+	}
+}
+
+func TestInvalidOutputs(t *testing.T) {
+	long := "DRONE_OUTPUT_BUILD_" + strings.Repeat("A", 64)
+	step := &Step{
+		Envs: map[string]string{
+			"DRONE_OUTPUT_BUILD_VERSION": "1.2.3",
+			long:                         "true",
+		},
+	}
+	invalid := invalidOutputs(&Spec{}, step)
+	if len(invalid) != 1 || invalid[long] == "" {
+		t.Errorf("Want output %s reported as invalid, got %v", long, invalid)
+	}
+	if len(invalidOutputs(&Spec{MultiPod: true}, step)) != 0 {
+		t.Errorf("Want outputs of step pods set in the environment")
+	}
+}
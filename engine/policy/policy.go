@@ -15,6 +15,7 @@ type (
 	Policy struct {
 		Conditions     manifest.Conditions `yaml:"match"`
 		Name           string
+		Cluster        string
 		Metadata       Metadata
 		Resources      Resources
 		NodeSelector   map[string]string `yaml:"node_selector"`
@@ -67,6 +68,12 @@ func (p *Policy) Apply(spec *engine.Spec) {
 		}
 	}
 
+	// apply the default cluster. a cluster selected by
+	// the pipeline configuration takes precedence.
+	if v := p.Cluster; v != "" && spec.Cluster == "" {
+		spec.Cluster = v
+	}
+
 	// apply labels.
 	// note that labels are appended as opposed to replaced
 	// to ensure they do not remove Drone internal defaults.
@@ -3,3 +3,27 @@
 // that can be found in the LICENSE file.
 
 package policy
+
+import (
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/engine"
+)
+
+func TestApply_Cluster(t *testing.T) {
+	policy := &Policy{Cluster: "sandbox"}
+
+	spec := &engine.Spec{}
+	policy.Apply(spec)
+	if got, want := spec.Cluster, "sandbox"; got != want {
+		t.Errorf("Want cluster %q, got %q", want, got)
+	}
+
+	// the cluster selected by the pipeline configuration
+	// takes precedence over the policy.
+	spec = &engine.Spec{Cluster: "prod"}
+	policy.Apply(spec)
+	if got, want := spec.Cluster, "prod"; got != want {
+		t.Errorf("Want cluster %q, got %q", want, got)
+	}
+}
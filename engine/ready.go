@@ -0,0 +1,118 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// interval, in seconds, between readiness checks.
+const readyPeriodSeconds = 2
+
+// errNotReady is returned when the service exits before
+// the readiness check succeeds.
+var errNotReady = errors.New("the service exited before it was ready")
+
+// helper function returns the container readiness probe
+// for the step readiness check. Init containers do not
+// support readiness probes.
+func toReadinessProbe(step *Step) *v1.Probe {
+	if step.Ready == nil || step.Init {
+		return nil
+	}
+	probe := &v1.Probe{
+		PeriodSeconds:    readyPeriodSeconds,
+		TimeoutSeconds:   1,
+		SuccessThreshold: 1,
+		FailureThreshold: 1,
+	}
+	switch ready := step.Ready; {
+	case ready.HTTP != nil:
+		probe.HTTPGet = &v1.HTTPGetAction{
+			Path: ready.HTTP.Path,
+			Port: intstr.FromInt(ready.HTTP.Port),
+		}
+	case len(ready.Exec) != 0:
+		probe.Exec = &v1.ExecAction{
+			Command: ready.Exec,
+		}
+	case ready.TCP != 0:
+		probe.TCPSocket = &v1.TCPSocketAction{
+			Port: intstr.FromInt(ready.TCP),
+		}
+	default:
+		return nil
+	}
+	return probe
+}
+
+// WaitReady blocks until the readiness check of the step
+// succeeds. The readiness timeout starts once the step
+// container is running, so that pulling the image does not
+// count against the timeout.
+func (k *Kubernetes) WaitReady(ctx context.Context, specv runtime.Spec, stepv runtime.Step) error {
+	spec := specv.(*Spec)
+	step := stepv.(*Step)
+
+	if toReadinessProbe(step) == nil {
+		return nil
+	}
+
+	k, err := k.cluster(spec.Cluster)
+	if err != nil {
+		return err
+	}
+
+	namespace, name := spec.PodSpec.Namespace, podName(spec, step)
+	err = k.waitFor(ctx, namespace, name, func(pod *v1.Pod) (bool, error) {
+		for _, cs := range containerStatuses(pod, step) {
+			if cs.Name != step.ID || !isStepImage(cs, step) {
+				continue
+			}
+			if cs.State.Terminated != nil {
+				return false, errNotReady
+			}
+			return cs.State.Running != nil, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ctxready := ctx
+	if step.Ready.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctxready, cancel = context.WithTimeout(ctx, step.Ready.Timeout)
+		defer cancel()
+	}
+
+	err = k.waitFor(ctxready, namespace, name, func(pod *v1.Pod) (bool, error) {
+		for _, cs := range containerStatuses(pod, step) {
+			if cs.Name != step.ID || !isStepImage(cs, step) {
+				continue
+			}
+			if cs.State.Terminated != nil {
+				return false, errNotReady
+			}
+			return cs.Ready, nil
+		}
+		return false, nil
+	})
+
+	// if the readiness timeout is exceeded, but the parent
+	// context is still active, the service is not ready.
+	if err != nil && ctx.Err() == nil && ctxready.Err() != nil {
+		return fmt.Errorf("the service was not ready after %s", step.Ready.Timeout)
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+)
+
+func TestToReadinessProbe(t *testing.T) {
+	step := &Step{}
+	if toReadinessProbe(step) != nil {
+		t.Errorf("Want no probe without a readiness check")
+	}
+
+	step.Ready = &Ready{TCP: 5432}
+	if probe := toReadinessProbe(step); probe == nil || probe.TCPSocket == nil || probe.TCPSocket.Port.IntValue() != 5432 {
+		t.Errorf("Want tcp probe on port 5432, got %v", probe)
+	}
+
+	step.Ready = &Ready{HTTP: &ReadyHTTP{Port: 8080, Path: "/healthz"}}
+	if probe := toReadinessProbe(step); probe == nil || probe.HTTPGet == nil || probe.HTTPGet.Path != "/healthz" {
+		t.Errorf("Want http probe on path /healthz, got %v", probe)
+	}
+
+	step.Ready = &Ready{Exec: []string{"pg_isready"}}
+	if probe := toReadinessProbe(step); probe == nil || probe.Exec == nil || probe.Exec.Command[0] != "pg_isready" {
+		t.Errorf("Want exec probe, got %v", probe)
+	}
+
+	// init containers do not support readiness probes.
+	step.Init = true
+	if toReadinessProbe(step) != nil {
+		t.Errorf("Want no probe for init steps")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/pkg/logger"
+
+	"github.com/gosimple/slug"
+	"github.com/hashicorp/go-multierror"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reap deletes the pods, secrets and ephemeral namespaces
+// created by the named runner that have exceeded the
+// expiration time defined by the io.drone.expires annotation.
+// These resources are orphaned when the runner crashes or
+// fails to destroy the pipeline environment. The resources
+// of other runners are never deleted, unless the runner name
+// is empty. The resources are deleted from the default
+// cluster and all named clusters.
+func (k *Kubernetes) Reap(ctx context.Context, machine string) error {
+	result := k.reap(ctx, machine)
+	for _, name := range k.Clusters() {
+		log := logger.FromContext(ctx).WithField("cluster", name)
+		err := k.clusters[name].reap(logger.WithContext(ctx, log), machine)
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+func (k *Kubernetes) reap(ctx context.Context, machine string) error {
+	log := logger.FromContext(ctx)
+	opts := metav1.ListOptions{LabelSelector: podSelector}
+	if machine != "" {
+		opts.LabelSelector += ",io.drone.runner=" + slug.Make(machine)
+	}
+	now := time.Now()
+
+	var result error
+
+	pods, err := k.client.CoreV1().Pods(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	} else {
+		for _, pod := range pods.Items {
+			if !isExpired(pod.ObjectMeta, now) {
+				continue
+			}
+			err := k.client.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				result = multierror.Append(result, err)
+				continue
+			}
+			log.WithField("namespace", pod.Namespace).
+				WithField("pod", pod.Name).
+				Infoln("reaper: deleted expired pod")
+		}
+	}
+
+	secrets, err := k.client.CoreV1().Secrets(metav1.NamespaceAll).List(opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	} else {
+		for _, secret := range secrets.Items {
+			if !isExpired(secret.ObjectMeta, now) {
+				continue
+			}
+			err := k.client.CoreV1().Secrets(secret.Namespace).Delete(secret.Name, &metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				result = multierror.Append(result, err)
+				continue
+			}
+			log.WithField("namespace", secret.Namespace).
+				WithField("secret", secret.Name).
+				WithField("type", secret.Type).
+				Infoln("reaper: deleted expired secret")
+		}
+	}
+
+	namespaces, err := k.client.CoreV1().Namespaces().List(opts)
+	if err != nil {
+		result = multierror.Append(result, err)
+	} else {
+		for _, namespace := range namespaces.Items {
+			// only ephemeral namespaces, created by the
+			// runner for a single pipeline, are deleted.
+			if !strings.HasPrefix(namespace.Name, "drone-") {
+				continue
+			}
+			if !isExpired(namespace.ObjectMeta, now) {
+				continue
+			}
+			err := k.client.CoreV1().Namespaces().Delete(namespace.Name, &metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				result = multierror.Append(result, err)
+				continue
+			}
+			log.WithField("namespace", namespace.Name).
+				Infoln("reaper: deleted expired namespace")
+		}
+	}
+
+	return result
+}
+
+// helper function returns true if the resource expiration
+// time is exceeded. Resources without a valid expiration
+// time never expire.
+func isExpired(meta metav1.ObjectMeta, now time.Time) bool {
+	v, ok := meta.Annotations["io.drone.expires"]
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return false
+	}
+	return now.Unix() > expires
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReap(t *testing.T) {
+	expired := fmt.Sprint(time.Now().Add(-time.Hour).Unix())
+	active := fmt.Sprint(time.Now().Add(time.Hour).Unix())
+
+	client := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: testMeta("default", "drone-expired", expired)},
+		&v1.Pod{ObjectMeta: testMeta("default", "drone-active", active)},
+		&v1.Secret{ObjectMeta: testMeta("default", "drone-expired", expired)},
+		&v1.Secret{ObjectMeta: testMeta("default", "drone-active", active)},
+		&v1.Namespace{ObjectMeta: testMeta("", "drone-expired", expired)},
+		&v1.Namespace{ObjectMeta: testMeta("", "production", expired)},
+		&v1.Pod{ObjectMeta: testOtherMeta("default", "drone-other", expired)},
+		&v1.Secret{ObjectMeta: testOtherMeta("default", "drone-other", expired)},
+		&v1.Namespace{ObjectMeta: testOtherMeta("", "drone-other", expired)},
+	)
+	engine := &Kubernetes{client: client}
+
+	if err := engine.Reap(context.Background(), "runner1"); err != nil {
+		t.Fatal(err)
+	}
+
+	pods, _ := client.CoreV1().Pods("default").List(metav1.ListOptions{})
+	if got, want := len(pods.Items), 2; got != want {
+		t.Errorf("Want %d pods, got %d", want, got)
+	}
+	for _, pod := range pods.Items {
+		if pod.Name == "drone-expired" {
+			t.Errorf("Want expired pod deleted")
+		}
+	}
+
+	secrets, _ := client.CoreV1().Secrets("default").List(metav1.ListOptions{})
+	if got, want := len(secrets.Items), 2; got != want {
+		t.Errorf("Want %d secrets, got %d", want, got)
+	}
+
+	namespaces, _ := client.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if got, want := len(namespaces.Items), 2; got != want {
+		t.Errorf("Want %d namespaces, got %d", want, got)
+	}
+	for _, namespace := range namespaces.Items {
+		if namespace.Name == "drone-expired" {
+			t.Errorf("Want expired namespace deleted")
+		}
+	}
+}
+
+func testMeta(namespace, name, expires string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   namespace,
+		Labels:      map[string]string{"io.drone": "true", "io.drone.runner": "runner1"},
+		Annotations: map[string]string{"io.drone.expires": expires},
+	}
+}
+
+// helper function returns the metadata of a resource created
+// by another runner.
+func testOtherMeta(namespace, name, expires string) metav1.ObjectMeta {
+	meta := testMeta(namespace, name, expires)
+	meta.Labels["io.drone.runner"] = "runner2"
+	return meta
+}
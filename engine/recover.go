@@ -0,0 +1,117 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gosimple/slug"
+	"github.com/hashicorp/go-multierror"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Orphan describes a pipeline pod created by a previous
+// runner process, for example, before the runner crashed
+// or was restarted.
+type Orphan struct {
+	Cluster   string
+	Namespace string
+	Name      string
+	StageID   int64
+}
+
+// Orphans returns the pipeline pods created by the named
+// runner instance, in the default cluster and all named
+// clusters. This should only be invoked on startup, before
+// the runner starts processing pipelines.
+func (k *Kubernetes) Orphans(ctx context.Context, machine, instance string) ([]*Orphan, error) {
+	orphans, err := k.orphans(machine, instance, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range k.Clusters() {
+		found, err := k.clusters[name].orphans(machine, instance, name)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, found...)
+	}
+	return orphans, nil
+}
+
+func (k *Kubernetes) orphans(machine, instance, cluster string) ([]*Orphan, error) {
+	// the pods are selected by runner name and instance, so
+	// the pods of other runner processes that share the same
+	// name are not mistaken for orphans.
+	pods, err := k.client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		LabelSelector: podSelector +
+			",io.drone.runner=" + slug.Make(machine) +
+			",io.drone.runner.instance=" + slug.Make(instance),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var orphans []*Orphan
+	for _, pod := range pods.Items {
+		// step pods are owned by the pipeline pod, and are
+		// garbage collected with the pipeline pod.
+		if isOwnedByPod(&pod) {
+			continue
+		}
+		id, _ := strconv.ParseInt(pod.Annotations["io.drone.stage.id"], 10, 64)
+		orphans = append(orphans, &Orphan{
+			Cluster:   cluster,
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			StageID:   id,
+		})
+	}
+	return orphans, nil
+}
+
+// DeleteOrphan deletes the orphaned pipeline pod. The pod
+// secrets are owned by the pod and are garbage collected
+// by Kubernetes. If the pod was created in an ephemeral
+// namespace, the namespace is deleted as well.
+func (k *Kubernetes) DeleteOrphan(ctx context.Context, orphan *Orphan) error {
+	k, err := k.cluster(orphan.Cluster)
+	if err != nil {
+		return err
+	}
+
+	var result error
+
+	err = k.client.CoreV1().Pods(orphan.Namespace).Delete(orphan.Name, &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		result = multierror.Append(result, err)
+	}
+
+	if strings.HasPrefix(orphan.Namespace, "drone-") {
+		namespace, err := k.client.CoreV1().Namespaces().Get(orphan.Namespace, metav1.GetOptions{})
+		if err == nil && namespace.Labels["io.drone"] == "true" {
+			err = k.client.CoreV1().Namespaces().Delete(orphan.Namespace, &metav1.DeleteOptions{})
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result
+}
+
+// helper function returns true if the pod is owned by
+// another pod.
+func isOwnedByPod(pod *v1.Pod) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "Pod" {
+			return true
+		}
+	}
+	return false
+}
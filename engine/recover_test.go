@@ -0,0 +1,58 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOrphans(t *testing.T) {
+	pod := func(name, instance string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels: map[string]string{
+					"io.drone":                 "true",
+					"io.drone.runner":          "runner1",
+					"io.drone.runner.instance": instance,
+				},
+				Annotations: map[string]string{
+					"io.drone.stage.id": "42",
+				},
+			},
+		}
+	}
+
+	owned := pod("drone-step", "host1")
+	owned.OwnerReferences = []metav1.OwnerReference{
+		{Kind: "Pod", Name: "drone-orphan"},
+	}
+	client := fake.NewSimpleClientset(
+		pod("drone-orphan", "host1"),
+		pod("drone-other", "host2"),
+		owned,
+	)
+	engine := &Kubernetes{client: client}
+
+	orphans, err := engine.Orphans(context.Background(), "runner1", "host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(orphans), 1; got != want {
+		t.Fatalf("Want %d orphans, got %d", want, got)
+	}
+	if got, want := orphans[0].Name, "drone-orphan"; got != want {
+		t.Errorf("Want orphan %s, got %s", want, got)
+	}
+	if got, want := orphans[0].StageID, int64(42); got != want {
+		t.Errorf("Want stage id %d, got %d", want, got)
+	}
+}
@@ -38,7 +38,10 @@ func match(r *manifest.RawResource) bool {
 func lint(pipeline *Pipeline) error {
 	// ensure pipeline steps are not unique.
 	names := map[string]struct{}{}
-	for _, step := range pipeline.Steps {
+	var steps []*Step
+	steps = append(steps, pipeline.Init...)
+	steps = append(steps, pipeline.Steps...)
+	for _, step := range steps {
 		if step == nil {
 			return errors.New("Linter: detected nil step")
 		}
@@ -4,7 +4,11 @@
 
 package resource
 
-import "github.com/ozonep/drone-runner-kube/pkg/manifest"
+import (
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/pkg/manifest"
+)
 
 var (
 	_ manifest.Resource          = (*Pipeline)(nil)
@@ -30,13 +34,19 @@ type Pipeline struct {
 	Name    string   `json:"name,omitempty"`
 	Deps    []string `json:"depends_on,omitempty"`
 
+	Cache       *Cache               `json:"cache,omitempty"`
 	Clone       manifest.Clone       `json:"clone,omitempty"`
+	Cluster     string               `json:"cluster,omitempty"`
 	Concurrency manifest.Concurrency `json:"concurrency,omitempty"`
+	Debug       bool                 `json:"debug,omitempty"`
+	MultiPod    bool                 `json:"multi_pod,omitempty" yaml:"multi_pod"`
 	Node        map[string]string    `json:"node,omitempty"`
 	Platform    manifest.Platform    `json:"platform,omitempty"`
+	ServicePods bool                 `json:"service_pods,omitempty" yaml:"service_pods"`
 	Trigger     manifest.Conditions  `json:"conditions,omitempty"`
 
 	Environment map[string]string `json:"environment,omitempty"`
+	Init        []*Step           `json:"init,omitempty"`
 	Services    []*Step           `json:"services,omitempty"`
 	Steps       []*Step           `json:"steps,omitempty"`
 	Volumes     []*Volume         `json:"volumes,omitempty"`
@@ -128,8 +138,11 @@ type (
 		Failure     string                         `json:"failure,omitempty"`
 		Image       string                         `json:"image,omitempty"`
 		Name        string                         `json:"name,omitempty"`
+		OnCancel    bool                           `json:"on_cancel,omitempty" yaml:"on_cancel"`
+		Ports       []*Port                        `json:"ports,omitempty"`
 		Privileged  bool                           `json:"privileged,omitempty"`
 		Pull        string                         `json:"pull,omitempty"`
+		Ready       *Ready                         `json:"ready,omitempty"`
 		Resources   Resources                      `json:"resource,omitempty"`
 		Settings    map[string]*manifest.Parameter `json:"settings,omitempty"`
 		Shell       string                         `json:"shell,omitempty"`
@@ -140,6 +153,27 @@ type (
 		WorkingDir  string                         `json:"working_dir,omitempty" yaml:"working_dir"`
 	}
 
+	// Port defines a port exposed by a service.
+	Port struct {
+		Port     int    `json:"port,omitempty"`
+		Protocol string `json:"protocol,omitempty"`
+	}
+
+	// Ready defines the check used to determine when a
+	// service is ready, and dependent steps can start.
+	Ready struct {
+		TCP     int           `json:"tcp,omitempty"`
+		HTTP    *ReadyHTTP    `json:"http,omitempty"`
+		Exec    []string      `json:"exec,omitempty"`
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// ReadyHTTP defines an http readiness check.
+	ReadyHTTP struct {
+		Port int    `json:"port,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
 	// Volume that can be mounted by containers.
 	Volume struct {
 		Name     string          `json:"name,omitempty"`
@@ -177,9 +211,36 @@ type (
 		ReadOnly  bool   `json:"read_only,omitempty" yaml:"read_only"`
 	}
 
+	// Cache configures the directories that are persisted
+	// between builds. The cache is keyed by repository and
+	// by the cache key, and is restored from the first
+	// fallback key that exists if the key does not exist.
+	Cache struct {
+		Key          string   `json:"key,omitempty"`
+		FallbackKeys []string `json:"fallback_keys,omitempty" yaml:"fallback_keys"`
+		Paths        []string `json:"paths,omitempty"`
+	}
+
 	// Workspace represents the pipeline workspace configuration.
 	Workspace struct {
-		Path string `json:"path,omitempty"`
+		Path   string           `json:"path,omitempty"`
+		Volume *WorkspaceVolume `json:"volume,omitempty"`
+	}
+
+	// WorkspaceVolume configures the volume that backs the
+	// workspace. The workspace is a temporary directory by
+	// default.
+	WorkspaceVolume struct {
+		EmptyDir *VolumeEmptyDir `json:"temp,omitempty" yaml:"temp"`
+		Claim    *WorkspaceClaim `json:"claim,omitempty" yaml:"claim"`
+	}
+
+	// WorkspaceClaim backs the workspace with a persistentVolumeClaim
+	// that is created when the pipeline starts, and deleted when
+	// the pipeline completes.
+	WorkspaceClaim struct {
+		StorageClass string             `json:"storage_class,omitempty" yaml:"storage_class"`
+		Size         manifest.BytesSize `json:"size,omitempty"`
 	}
 
 	// Resources describes the compute resource
@@ -201,3 +262,21 @@ type (
 		Memory manifest.BytesSize `json:"memory"`
 	}
 )
+
+// UnmarshalYAML implements yaml unmarshalling. The port can
+// be defined as a number, or as a port and protocol.
+func (p *Port) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var port int
+	if err := unmarshal(&port); err == nil {
+		p.Port = port
+		return nil
+	}
+	out := struct {
+		Port     int
+		Protocol string
+	}{}
+	err := unmarshal(&out)
+	p.Port = out.Port
+	p.Protocol = out.Protocol
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// backoff used to retry transient errors when creating the
+// pipeline environment.
+var setupBackoff = wait.Backoff{
+	Steps:    6,
+	Duration: 500 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// object describes a Kubernetes object created by Setup.
+type object struct {
+	kind string
+	name string
+
+	// create creates the object.
+	create func() error
+
+	// get returns the object metadata. It is used to verify
+	// an existing object belongs to the pipeline.
+	get func() (metav1.Object, error)
+
+	// delete deletes the object.
+	delete func() error
+
+	// patch applies a merge patch to the object. It is used
+	// to set the pod as the owner of the object, and is nil
+	// if the object is not owned by the pod.
+	patch func(data []byte) error
+}
+
+// helper function creates the object, retrying transient
+// errors. If the object already exists, and belongs to the
+// pipeline, it was created by a previous attempt and the
+// error is ignored.
+func createObject(ctx context.Context, spec *Spec, obj *object) error {
+	err := retryTransient(ctx, obj.create)
+	if k8serrors.IsAlreadyExists(err) && isOwnObject(spec, obj) {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create %s %s: %w", obj.kind, obj.name, err)
+	}
+	return nil
+}
+
+// helper function returns true if the existing object was
+// created for the pipeline.
+func isOwnObject(spec *Spec, obj *object) bool {
+	meta, err := obj.get()
+	if err != nil {
+		return false
+	}
+	return meta.GetLabels()["io.drone.name"] == spec.PodSpec.Name
+}
+
+// helper function invokes the function, and retries with
+// backoff if the function returns a transient error.
+func retryTransient(ctx context.Context, fn func() error) error {
+	var last error
+	err := wait.ExponentialBackoff(setupBackoff, func() (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		last = fn()
+		switch {
+		case last == nil:
+			return true, nil
+		case isTransient(last):
+			return false, nil
+		default:
+			return false, last
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		return last
+	}
+	return err
+}
+
+// helper function returns true if the error is transient,
+// and the request should be retried.
+func isTransient(err error) bool {
+	switch {
+	case k8serrors.IsServerTimeout(err),
+		k8serrors.IsTimeout(err),
+		k8serrors.IsTooManyRequests(err),
+		k8serrors.IsInternalError(err),
+		k8serrors.IsServiceUnavailable(err),
+		k8serrors.IsUnexpectedServerError(err):
+		return true
+	}
+	var neterr net.Error
+	return errors.As(err, &neterr) && neterr.Timeout()
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testSetupSpec() *Spec {
+	return &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone.name": "drone-test"},
+		},
+	}
+}
+
+// This test verifies transient errors are retried.
+func TestSetup_Retry(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	attempts := 0
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts == 1 {
+			return true, nil, k8serrors.NewInternalError(errors.New("webhook timeout"))
+		}
+		return false, nil, nil
+	})
+
+	engine := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	if err := engine.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	defer spec.events.stop()
+
+	if got, want := attempts, 2; got != want {
+		t.Errorf("Want %d attempts, got %d", want, got)
+	}
+}
+
+// This test verifies an object that already exists, and was
+// created for the pipeline, is treated as created.
+func TestSetup_AlreadyExists(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone.name": "drone-test"},
+		},
+	})
+
+	engine := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	if err := engine.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	spec.events.stop()
+
+	// an existing object that was not created for the
+	// pipeline is an error.
+	client = fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-test",
+			Namespace: "default",
+		},
+	})
+	engine = &Kubernetes{client: client}
+	if err := engine.Setup(context.Background(), testSetupSpec()); err == nil {
+		t.Errorf("Want error when the secret belongs to another pipeline")
+	}
+}
+
+// This test verifies the objects already created are deleted
+// if setup fails with a permanent error, and the error names
+// the object that failed.
+func TestSetup_Rollback(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewForbidden(v1.Resource("pods"), "drone-test", errors.New("exceeded quota"))
+	})
+
+	engine := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	spec.Namespace = "drone-test"
+	spec.PodSpec.Namespace = "drone-test"
+
+	err := engine.Setup(context.Background(), spec)
+	if err == nil {
+		t.Fatalf("Want setup error")
+	}
+	if !strings.HasPrefix(err.Error(), "cannot create pod drone-test:") {
+		t.Errorf("Want error to name the pod, got %q", err)
+	}
+
+	_, err = client.CoreV1().Secrets("drone-test").Get("drone-test", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Want secret deleted, got %v", err)
+	}
+	_, err = client.CoreV1().Namespaces().Get("drone-test", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Want namespace deleted, got %v", err)
+	}
+}
+
+// This test verifies claims are created before the pod, are
+// owned by the pod, and are deleted when the pipeline
+// environment is destroyed.
+func TestSetup_Claim(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	engine := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	spec.Volumes = []*Volume{
+		{
+			Claim: &VolumeClaim{
+				ID:           "workspace",
+				ClaimName:    "drone-workspace",
+				Create:       true,
+				StorageClass: "fast",
+				Size:         1 << 30,
+			},
+		},
+	}
+	if err := engine.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	claim, err := client.CoreV1().PersistentVolumeClaims("default").Get("drone-workspace", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *claim.Spec.StorageClassName, "fast"; got != want {
+		t.Errorf("Want storage class %s, got %s", want, got)
+	}
+	size := claim.Spec.Resources.Requests[v1.ResourceStorage]
+	if got, want := size.String(), "1Gi"; got != want {
+		t.Errorf("Want size %s, got %s", want, got)
+	}
+	if len(claim.OwnerReferences) != 1 || claim.OwnerReferences[0].Name != "drone-test" {
+		t.Errorf("Want claim owned by the pod")
+	}
+
+	if err := engine.Destroy(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CoreV1().PersistentVolumeClaims("default").Get("drone-workspace", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Want claim deleted, got %v", err)
+	}
+}
+
+func TestSetup_Service(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		service := action.(k8stesting.CreateAction).GetObject().(*v1.Service)
+		service.Spec.ClusterIP = "10.0.0.1"
+		return false, nil, nil
+	})
+
+	engine := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	spec.MultiPod = true
+	spec.Steps = []*Step{
+		{
+			ID:     "drone-redis",
+			Name:   "redis",
+			Detach: true,
+			Ports:  []Port{{Port: 6379}},
+		},
+	}
+	if err := engine.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := client.CoreV1().Services("default").Get("redis-test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := service.Spec.Selector["io.drone.name"], "drone-test"; got != want {
+		t.Errorf("Want service selector %s, got %s", want, got)
+	}
+	if len(service.OwnerReferences) != 1 || service.OwnerReferences[0].Name != "drone-test" {
+		t.Errorf("Want service owned by the pod")
+	}
+	if len(spec.aliases) != 1 || spec.aliases[0].IP != "10.0.0.1" || spec.aliases[0].Hostnames[0] != "redis" {
+		t.Errorf("Want service host alias, got %v", spec.aliases)
+	}
+	if spec.owner == nil || spec.owner.Name != "drone-test" {
+		t.Errorf("Want pipeline pod owner reference")
+	}
+
+	if err := engine.Destroy(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CoreV1().Services("default").Get("redis-test", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Want service deleted, got %v", err)
+	}
+}
+
+func TestSetup_DisruptionBudget(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	engine := &Kubernetes{client: client}
+	spec := testSetupSpec()
+	spec.DisruptionBudget = true
+	if err := engine.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	budget, err := client.PolicyV1beta1().PodDisruptionBudgets("default").Get("drone-test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := budget.Spec.MaxUnavailable.IntValue(), 0; got != want {
+		t.Errorf("Want max unavailable %d, got %d", want, got)
+	}
+	if got, want := budget.Spec.Selector.MatchExpressions[0].Values, []string{"drone-test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want budget selector %v, got %v", want, got)
+	}
+	if len(budget.OwnerReferences) != 1 || budget.OwnerReferences[0].Name != "drone-test" {
+		t.Errorf("Want budget owned by the pod")
+	}
+
+	if err := engine.Destroy(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.PolicyV1beta1().PodDisruptionBudgets("default").Get("drone-test", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("Want budget deleted, got %v", err)
+	}
+}
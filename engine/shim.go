@@ -0,0 +1,174 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/ozonep/drone-runner-kube/internal/shim"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// path of the shim executable in the shim image.
+	shimExecutable = "/bin/drone-kube-shim"
+
+	// path the shim volume is mounted to in the step
+	// containers.
+	shimPath = "/run/drone-shim"
+
+	// path the signal volume is mounted to in the step
+	// containers.
+	shimSignalPath = "/run/drone-signal"
+
+	shimVolume       = "drone-shim"
+	shimSignalVolume = "drone-shim-signal"
+	shimInitName     = "drone-shim"
+)
+
+// helper function returns true if the step is started by
+// the entrypoint shim. The step container is created with
+// the step image, and the shim blocks the container until the
+// step is started. Steps without an entrypoint, for example,
+// plugin steps, use the image entrypoint, which cannot be
+// wrapped by the shim. These steps fall back to the
+// placeholder image, and are started with a pod update that
+// replaces the image, as if the shim were disabled.
+func isShimStep(spec *Spec, step *Step) bool {
+	if spec.Shim == nil || step.Init || len(step.Entrypoint) == 0 {
+		return false
+	}
+	if spec.Debug != nil && spec.Debug.Step != nil && spec.Debug.Step.ID == step.ID {
+		return false
+	}
+	return !isSeparatePod(spec, step)
+}
+
+// helper function returns the annotation the start and kill
+// signals are written to. The annotation is exposed to the
+// step container with the downward api.
+//
+// The kubelet refreshes downward api volumes when it syncs
+// the pod, so the signal can reach the shim up to the kubelet
+// sync period (one minute by default) after the annotation is
+// written. The steps can therefore start, and be stopped,
+// later than steps that are started with a pod update.
+func shimSignalKey(step *Step) string {
+	return "io.drone.signal." + step.ID
+}
+
+// helper function returns the shim volumes. The shim is
+// copied to an empty directory by the init container, and
+// the step signals are exposed with the downward api.
+func toShimVolumes(spec *Spec) []v1.Volume {
+	var items []v1.DownwardAPIVolumeFile
+	for _, step := range spec.Steps {
+		if isShimStep(spec, step) {
+			items = append(items, v1.DownwardAPIVolumeFile{
+				Path: step.ID,
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "metadata.annotations['" + shimSignalKey(step) + "']",
+				},
+			})
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return []v1.Volume{
+		{
+			Name: shimVolume,
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name: shimSignalVolume,
+			VolumeSource: v1.VolumeSource{
+				DownwardAPI: &v1.DownwardAPIVolumeSource{
+					Items: items,
+				},
+			},
+		},
+	}
+}
+
+// helper function returns the init container that copies
+// the shim to the shim volume.
+func toShimInitContainers(spec *Spec) []v1.Container {
+	if toShimVolumes(spec) == nil {
+		return nil
+	}
+	return []v1.Container{
+		{
+			Name:    shimInitName,
+			Image:   spec.Shim.Image,
+			Command: []string{shimExecutable, "-install", path.Join(shimPath, "shim")},
+			VolumeMounts: []v1.VolumeMount{
+				{
+					Name:      shimVolume,
+					MountPath: shimPath,
+				},
+			},
+		},
+	}
+}
+
+// helper function wraps the step container entrypoint with
+// the shim. The container is created with the step image, and
+// the shim blocks the step until the start signal is written.
+// If the step is killed, the shim sends the termination signal,
+// and kills the step once the grace period expires.
+func toShimContainer(spec *Spec, step *Step, container *v1.Container) {
+	container.Image = step.Image
+	container.Command = append([]string{
+		path.Join(shimPath, "shim"),
+		"-signal", path.Join(shimSignalPath, step.ID),
+		"-exit", path.Join(shimPath, step.ID+".exit"),
+		"-grace", toGracePeriod(spec).String(),
+		"--",
+	}, step.Entrypoint...)
+	container.VolumeMounts = append(container.VolumeMounts,
+		v1.VolumeMount{
+			Name:      shimVolume,
+			MountPath: shimPath,
+		},
+		v1.VolumeMount{
+			Name:      shimSignalVolume,
+			MountPath: shimSignalPath,
+			ReadOnly:  true,
+		},
+	)
+}
+
+// helper function writes the signal to the step signal
+// annotation. The current pipeline status, and the outputs
+// of the step dependencies, are written with the start
+// signal. The annotations are updated with a merge patch, so
+// concurrent steps do not conflict.
+func (k *Kubernetes) signal(ctx context.Context, spec *Spec, step *Step, signal string) error {
+	annotations := map[string]string{}
+	if signal == shim.SignalStart {
+		annotations = toStepAnnotations(step)
+	}
+	annotations[shimSignalKey(step)] = signal
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return retryTransient(ctx, func() error {
+		_, err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Patch(spec.PodSpec.Name, types.MergePatchType, patch)
+		return err
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+)
+
+func TestToPod_Shim(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Name:      "drone-test",
+			Namespace: "default",
+		},
+		Shim: &Shim{Image: "drone/drone-runner-kube"},
+		Steps: []*Step{
+			{
+				ID:          "drone-build",
+				Name:        "build",
+				Image:       "golang:1",
+				Placeholder: "drone/placeholder:1",
+				Entrypoint:  []string{"/bin/sh", "-c"},
+				Command:     []string{"go build"},
+			},
+			{
+				ID:          "drone-plugin",
+				Name:        "plugin",
+				Image:       "plugins/slack",
+				Placeholder: "drone/placeholder:1",
+			},
+		},
+	}
+
+	pod := toPod(spec)
+	if got, want := len(pod.Spec.InitContainers), 1; got != want {
+		t.Fatalf("Want %d init containers, got %d", want, got)
+	}
+	if got, want := pod.Spec.InitContainers[0].Image, "drone/drone-runner-kube"; got != want {
+		t.Errorf("Want shim image %s, got %s", want, got)
+	}
+
+	build, plugin := pod.Spec.Containers[0], pod.Spec.Containers[1]
+	if got, want := build.Image, "golang:1"; got != want {
+		t.Errorf("Want step image %s, got %s", want, got)
+	}
+	if got, want := build.Command[0], "/run/drone-shim/shim"; got != want {
+		t.Errorf("Want shim entrypoint %s, got %s", want, got)
+	}
+	if got, want := build.Command[len(build.Command)-2], "/bin/sh"; got != want {
+		t.Errorf("Want step entrypoint %s, got %s", want, got)
+	}
+
+	// steps without an entrypoint use the image entrypoint,
+	// and are started by replacing the placeholder image.
+	if got, want := plugin.Image, "drone/placeholder:1"; got != want {
+		t.Errorf("Want placeholder image %s, got %s", want, got)
+	}
+
+	var items int
+	for _, volume := range pod.Spec.Volumes {
+		if volume.DownwardAPI != nil && volume.Name == shimSignalVolume {
+			items = len(volume.DownwardAPI.Items)
+		}
+	}
+	if got, want := items, 1; got != want {
+		t.Errorf("Want %d signal files, got %d", want, got)
+	}
+}
@@ -6,9 +6,12 @@ package engine
 
 import (
 	"sync"
+	"time"
 
 	"github.com/ozonep/drone-runner-kube/pkg/environ"
 	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type (
@@ -22,11 +25,85 @@ type (
 		Volumes    []*Volume          `json:"volumes,omitempty"`
 		Secrets    map[string]*Secret `json:"secrets,omitempty"`
 		PullSecret *Secret            `json:"pull_secrets,omitempty"`
+		Debug      *Debug             `json:"debug,omitempty"`
 		// Runtime field to gate updating of the pod that this pipeline
 		// is running on. Helps to avoid self-inflicted 409 Conflict
 		// responses from the kubernetes api server.
 		podUpdateMutex sync.Mutex
 
+		// Runtime field to track the step log streams, used
+		// to ensure logs are fully streamed before the pod
+		// is deleted.
+		logs logStreams
+
+		// Runtime field to stream the pod events to the logs
+		// of the steps waiting to start.
+		events *eventStream
+
+		// Runtime field to ensure the pipeline pod proxy is
+		// only awaited once, before the first step starts.
+		proxyOnce sync.Once
+		proxyErr  error
+
+		// Runtime field to track whether the pod informer of
+		// the pipeline namespace is held by the pipeline.
+		informed bool
+
+		// Runtime field to ensure only one failed step is
+		// debugged at a time.
+		debugOnce sync.Once
+
+		// Runtime field to store the pipeline pod owner
+		// reference, used to set the pipeline pod as the owner
+		// of the step pods in multi-pod mode.
+		owner *metav1.OwnerReference
+
+		// Runtime field to store the service host aliases,
+		// used to resolve the service names from the step pods
+		// in multi-pod mode.
+		aliases []HostAlias
+
+		// Cluster is the optional name of the cluster the
+		// pipeline is routed to. If empty, the pipeline runs
+		// in the default cluster.
+		Cluster string `json:"cluster,omitempty"`
+
+		// MultiPod configures the pipeline steps to run in
+		// separate pods that share the workspace, instead of
+		// running all steps in the pipeline pod. The services
+		// run in the pipeline pod, and are reachable from the
+		// step pods through Kubernetes services.
+		MultiPod bool `json:"multi_pod,omitempty"`
+
+		// ServicePods configures the pipeline services to run
+		// in separate pods, reachable from the pipeline through
+		// Kubernetes services, so that services listening on
+		// the same port do not collide.
+		ServicePods bool `json:"service_pods,omitempty"`
+
+		// Shim configures the steps to start with the step
+		// image, blocked by an entrypoint shim until the step
+		// is started, instead of starting with a placeholder
+		// image that is replaced when the step is started.
+		Shim *Shim `json:"shim,omitempty"`
+
+		// Mesh configures the handling of the service mesh
+		// proxy injected into the pipeline pods. If nil, the
+		// injected containers are ignored.
+		Mesh *Mesh `json:"mesh,omitempty"`
+
+		// DisruptionBudget configures a pod disruption budget
+		// that prevents the pipeline pods from being evicted,
+		// for example, by a node drain, while the pipeline is
+		// running.
+		DisruptionBudget bool `json:"disruption_budget,omitempty"`
+
+		// GracePeriod is the duration the step containers are
+		// given to exit after they are sent the termination
+		// signal, before they are killed. If zero, the cluster
+		// default is used.
+		GracePeriod time.Duration `json:"grace_period,omitempty"`
+
 		// Namespace is an optional namespace that should be
 		// created before the pipeline starts and executed after
 		// the pipeline completes. WARNING this field should only
@@ -37,6 +114,7 @@ type (
 	// Step defines a pipeline step.
 	Step struct {
 		ID           string            `json:"id,omitempty"`
+		Init         bool              `json:"init,omitempty"`
 		Command      []string          `json:"args,omitempty"`
 		Detach       bool              `json:"detach,omitempty"`
 		DependsOn    []string          `json:"depends_on,omitempty"`
@@ -47,8 +125,11 @@ type (
 		IgnoreStderr bool              `json:"ignore_stdout,omitempty"`
 		Image        string            `json:"image,omitempty"`
 		Name         string            `json:"name,omitempty"`
+		OnCancel     bool              `json:"on_cancel,omitempty"`
 		Placeholder  string            `json:"placeholder,omitempty"`
+		Ports        []Port            `json:"ports,omitempty"`
 		Privileged   bool              `json:"privileged,omitempty"`
+		Ready        *Ready            `json:"ready,omitempty"`
 		Resources    Resources         `json:"resources,omitempty"`
 		Pull         PullPolicy        `json:"pull,omitempty"`
 		RunPolicy    runtime.RunPolicy `json:"run_policy,omitempty"`
@@ -60,6 +141,54 @@ type (
 		WorkingDir   string            `json:"working_dir,omitempty"`
 	}
 
+	// Debug defines the container used to debug a failed
+	// step interactively. The container is created with the
+	// pod, and started with the failed step image on demand.
+	Debug struct {
+		Step    *Step         `json:"step,omitempty"`
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// Shim defines the entrypoint shim.
+	Shim struct {
+		// Image is the image the shim is copied from.
+		Image string `json:"image,omitempty"`
+	}
+
+	// Mesh defines the service mesh proxy injected into the
+	// pipeline pods.
+	Mesh struct {
+		// Proxy is the name of the injected proxy container.
+		Proxy string `json:"proxy,omitempty"`
+
+		// QuitPort and QuitPath define the proxy endpoint
+		// that stops the proxy. If the port is zero, the
+		// proxy is not stopped before the pod is deleted.
+		QuitPort int    `json:"quit_port,omitempty"`
+		QuitPath string `json:"quit_path,omitempty"`
+	}
+
+	// Port defines a port exposed by a service.
+	Port struct {
+		Port     int    `json:"port,omitempty"`
+		Protocol string `json:"protocol,omitempty"`
+	}
+
+	// Ready defines the check used to determine when a
+	// detached step is ready, and dependent steps can start.
+	Ready struct {
+		TCP     int           `json:"tcp,omitempty"`
+		HTTP    *ReadyHTTP    `json:"http,omitempty"`
+		Exec    []string      `json:"exec,omitempty"`
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	// ReadyHTTP defines an http readiness check.
+	ReadyHTTP struct {
+		Port int    `json:"port,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
 	// Platform defines the target platform.
 	Platform struct {
 		OS      string `json:"os,omitempty"`
@@ -135,12 +264,19 @@ type (
 		FieldPath string `json:"field_path,omitempty"`
 	}
 
-	// VolumeClaim ...
+	// VolumeClaim mounts a persistentVolumeClaim. If create
+	// is true, the claim is created with the storage class
+	// and size when the pipeline environment is created, and
+	// deleted when the pipeline environment is destroyed.
 	VolumeClaim struct {
-		ID        string `json:"id,omitempty"`
-		Name      string `json:"name,omitempty"`
-		ClaimName string `json:"claim_name,omitempty"`
-		ReadOnly  bool   `json:"read_only,omitempty"`
+		ID           string `json:"id,omitempty"`
+		Name         string `json:"name,omitempty"`
+		ClaimName    string `json:"claim_name,omitempty"`
+		ReadOnly     bool   `json:"read_only,omitempty"`
+		Create       bool   `json:"create,omitempty"`
+		StorageClass string `json:"storage_class,omitempty"`
+		Size         int64  `json:"size,omitempty"`
+		AccessMode   string `json:"access_mode,omitempty"`
 	}
 
 	// Resources describes the compute resource requirements.
@@ -224,6 +360,7 @@ func (s *Step) GetRunPolicy() runtime.RunPolicy  { return s.RunPolicy }
 func (s *Step) GetSecretAt(i int) runtime.Secret { return s.SpecSecrets[i] }
 func (s *Step) GetSecretLen() int                { return len(s.SpecSecrets) }
 func (s *Step) IsDetached() bool                 { return s.Detach }
+func (s *Step) IsOnCancel() bool                 { return s.OnCancel }
 func (s *Step) Clone() runtime.Step {
 	dst := new(Step)
 	*dst = *s
@@ -0,0 +1,205 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ozonep/drone-runner-kube/internal/docker/image"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ErrDeadlineExceeded is returned when Kubernetes terminates
+// the pod for exceeding its active deadline. It wraps the
+// context deadline error so the pipeline is treated as timed
+// out, as opposed to failed with an internal error.
+var ErrDeadlineExceeded = fmt.Errorf("pod exceeded its active deadline: %w", context.DeadlineExceeded)
+
+// errPodFailed is returned when the pod fails before the
+// step starts, for example, because an init step failed.
+var errPodFailed = errors.New("the pod failed before the step started")
+
+// pod status reason set by the kubelet when the pod exceeds
+// its active deadline.
+const podReasonDeadlineExceeded = "DeadlineExceeded"
+
+// pod status reasons that indicate the pod was disrupted, for
+// example, evicted by the kubelet under node pressure, or lost
+// with its node.
+var disruptedReasons = map[string]bool{
+	"Evicted":      true,
+	"NodeLost":     true,
+	"NodeShutdown": true,
+	"Shutdown":     true,
+	"Preempting":   true,
+}
+
+// pod condition, and condition reasons, set by Kubernetes when
+// the pod is about to be deleted because of a disruption, for
+// example, a node drain or priority preemption.
+const podConditionDisruptionTarget = "DisruptionTarget"
+
+var disruptionTargetReasons = map[string]bool{
+	"PreemptionByScheduler":     true,
+	"PreemptionByKubeScheduler": true,
+	"DeletionByTaintManager":    true,
+	"EvictionByEvictionAPI":     true,
+	"DeletionByPodGC":           true,
+	"TerminationByKubelet":      true,
+}
+
+// waiting reasons that indicate the container will never
+// start without user intervention.
+var fatalReasons = map[string]bool{
+	"ErrImageNeverPull":          true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+// waiting reasons that indicate the container may not start,
+// but where Kubernetes continues to retry.
+var pendingReasons = map[string]bool{
+	"ErrImagePull": true,
+}
+
+// PodError describes a condition, reported by Kubernetes,
+// that prevents a pipeline step from starting.
+type PodError struct {
+	Reason  string
+	Message string
+	Node    string
+	Image   string
+}
+
+// Error returns the error string.
+func (e *PodError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Reason)
+	if e.Message != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Message)
+	}
+	if e.Node != "" {
+		fmt.Fprintf(&b, " (node %s)", e.Node)
+	}
+	if e.Image != "" {
+		fmt.Fprintf(&b, " (image %s)", e.Image)
+	}
+	return b.String()
+}
+
+// DisruptionError describes a disruption, reported by
+// Kubernetes, that terminated the pipeline pod, for example,
+// an eviction, a preemption, or the loss of the node. It wraps
+// the runtime disruption error, so the stage can be retried.
+type DisruptionError struct {
+	Reason  string
+	Message string
+	Node    string
+}
+
+// Error returns the error string.
+func (e *DisruptionError) Error() string {
+	var b strings.Builder
+	b.WriteString("pod disrupted: ")
+	b.WriteString(e.Reason)
+	if e.Message != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Message)
+	}
+	if e.Node != "" {
+		fmt.Fprintf(&b, " (node %s)", e.Node)
+	}
+	return b.String()
+}
+
+// Unwrap returns the runtime disruption error.
+func (e *DisruptionError) Unwrap() error {
+	return runtime.ErrDisrupted
+}
+
+// helper function inspects the pod status and returns an
+// error describing why the pod was disrupted, or nil if the
+// pod was not disrupted.
+func checkDisrupted(pod *v1.Pod) *DisruptionError {
+	if disruptedReasons[pod.Status.Reason] {
+		return &DisruptionError{
+			Reason:  pod.Status.Reason,
+			Message: pod.Status.Message,
+			Node:    pod.Spec.NodeName,
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == podConditionDisruptionTarget &&
+			cond.Status == v1.ConditionTrue &&
+			disruptionTargetReasons[cond.Reason] {
+			return &DisruptionError{
+				Reason:  cond.Reason,
+				Message: cond.Message,
+				Node:    pod.Spec.NodeName,
+			}
+		}
+	}
+	return nil
+}
+
+// helper function inspects the pod status and returns an
+// error describing why the step container has not started.
+// The boolean value reports whether the error is fatal. A
+// nil error is returned if the step is starting normally.
+func checkPending(pod *v1.Pod, step *Step) (*PodError, bool) {
+	for _, cs := range containerStatuses(pod, step) {
+		if cs.Name != step.ID || cs.State.Waiting == nil {
+			continue
+		}
+		waiting := cs.State.Waiting
+		if !fatalReasons[waiting.Reason] && !pendingReasons[waiting.Reason] {
+			continue
+		}
+		err := &PodError{
+			Reason:  waiting.Reason,
+			Message: waiting.Message,
+			Node:    pod.Spec.NodeName,
+			Image:   step.Image,
+		}
+		return err, fatalReasons[waiting.Reason]
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodScheduled &&
+			cond.Status == v1.ConditionFalse &&
+			cond.Reason == v1.PodReasonUnschedulable {
+			return &PodError{
+				Reason:  cond.Reason,
+				Message: cond.Message,
+				Image:   step.Image,
+			}, false
+		}
+	}
+	return nil, false
+}
+
+// helper function returns the pod container statuses that
+// include the step container. Init steps are reported in
+// the init container statuses.
+func containerStatuses(pod *v1.Pod, step *Step) []v1.ContainerStatus {
+	if step.Init {
+		return pod.Status.InitContainerStatuses
+	}
+	return pod.Status.ContainerStatuses
+}
+
+// helper function returns true if the container status is
+// reported for the step image, and not the placeholder
+// image. Init steps do not use a placeholder image.
+func isStepImage(cs v1.ContainerStatus, step *Step) bool {
+	return step.Init || !image.Match(cs.Image, step.Placeholder)
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestCheckPending_Fatal(t *testing.T) {
+	step := &Step{ID: "step1", Image: "golang:1.x"}
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{NodeName: "node1"},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name: "step1",
+					State: v1.ContainerState{
+						Waiting: &v1.ContainerStateWaiting{
+							Reason:  "ImagePullBackOff",
+							Message: "Back-off pulling image",
+						},
+					},
+				},
+			},
+		},
+	}
+	err, fatal := checkPending(pod, step)
+	if err == nil {
+		t.Fatalf("Expect pending error")
+	}
+	if !fatal {
+		t.Errorf("Expect fatal error")
+	}
+	if got, want := err.Error(), "ImagePullBackOff: Back-off pulling image (node node1) (image golang:1.x)"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestCheckPending_Unschedulable(t *testing.T) {
+	step := &Step{ID: "step1", Image: "golang:1.x"}
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{
+				{
+					Type:    v1.PodScheduled,
+					Status:  v1.ConditionFalse,
+					Reason:  v1.PodReasonUnschedulable,
+					Message: "0/3 nodes are available",
+				},
+			},
+		},
+	}
+	err, fatal := checkPending(pod, step)
+	if err == nil {
+		t.Fatalf("Expect pending error")
+	}
+	if fatal {
+		t.Errorf("Expect non-fatal error")
+	}
+	if got, want := err.Reason, v1.PodReasonUnschedulable; got != want {
+		t.Errorf("Want reason %q, got %q", want, got)
+	}
+}
+
+func TestCheckPending_Running(t *testing.T) {
+	step := &Step{ID: "step1", Image: "golang:1.x"}
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name: "step1",
+					State: v1.ContainerState{
+						Waiting: &v1.ContainerStateWaiting{
+							Reason: "ContainerCreating",
+						},
+					},
+				},
+			},
+		},
+	}
+	if err, _ := checkPending(pod, step); err != nil {
+		t.Errorf("Expect no pending error, got %s", err)
+	}
+}
+
+func TestCheckDisrupted_Evicted(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{NodeName: "node1"},
+		Status: v1.PodStatus{
+			Phase:   v1.PodFailed,
+			Reason:  "Evicted",
+			Message: "The node was low on resource: memory.",
+		},
+	}
+	err := checkDisrupted(pod)
+	if err == nil {
+		t.Fatalf("Expect disruption error")
+	}
+	if got, want := err.Error(), "pod disrupted: Evicted: The node was low on resource: memory. (node node1)"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+	if !errors.Is(err, runtime.ErrDisrupted) {
+		t.Errorf("Expect disruption error wraps the runtime disruption error")
+	}
+}
+
+func TestCheckDisrupted_Preempted(t *testing.T) {
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{
+				{
+					Type:    podConditionDisruptionTarget,
+					Status:  v1.ConditionTrue,
+					Reason:  "PreemptionByScheduler",
+					Message: "Preempted by a higher priority pod",
+				},
+			},
+		},
+	}
+	err := checkDisrupted(pod)
+	if err == nil {
+		t.Fatalf("Expect disruption error")
+	}
+	if got, want := err.Reason, "PreemptionByScheduler"; got != want {
+		t.Errorf("Want reason %q, got %q", want, got)
+	}
+}
+
+func TestCheckDisrupted_Running(t *testing.T) {
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+		},
+	}
+	if err := checkDisrupted(pod); err != nil {
+		t.Errorf("Expect no disruption error, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// drainTimeout defines the maximum amount of time to wait
+// for the log streams to reach EOF before the pipeline
+// environment is destroyed.
+var drainTimeout = time.Minute
+
+// streamCloseDelay defines the amount of time the log stream
+// of a cancelled step remains open, so the step can write its
+// final logs as it exits, before the stream is closed.
+var streamCloseDelay = 5 * time.Second
+
+// logClient opens the log stream of a pod container.
+type logClient interface {
+	Stream(namespace, name, container string) (io.ReadCloser, error)
+}
+
+// restLogs implements a log client that streams the container
+// logs from the pod log api.
+type restLogs struct {
+	client rest.Interface
+}
+
+// Stream opens the container log stream, following the logs
+// until the container exits.
+func (l *restLogs) Stream(namespace, name, container string) (io.ReadCloser, error) {
+	opts := &v1.PodLogOptions{
+		Follow:    true,
+		Container: container,
+	}
+	return l.client.Get().
+		Namespace(namespace).
+		Name(name).
+		Resource("pods").
+		SubResource("log").
+		VersionedParams(opts, scheme.ParameterCodec).
+		Stream()
+}
+
+// logStreams tracks the log streams opened for each of the
+// pipeline steps, and whether or not the stream has reached
+// EOF.
+type logStreams struct {
+	sync.Mutex
+	streams map[string]*logStream
+}
+
+type logStream struct {
+	detached bool
+	done     chan struct{}
+}
+
+// open marks the step log stream as open.
+func (s *logStreams) open(step *Step) {
+	s.Lock()
+	if s.streams == nil {
+		s.streams = map[string]*logStream{}
+	}
+	s.streams[step.ID] = &logStream{
+		detached: step.Detach,
+		done:     make(chan struct{}),
+	}
+	s.Unlock()
+}
+
+// close marks the step log stream as complete.
+func (s *logStreams) close(step *Step) {
+	s.Lock()
+	if stream, ok := s.streams[step.ID]; ok {
+		close(stream.done)
+		delete(s.streams, step.ID)
+	}
+	s.Unlock()
+}
+
+// wait blocks until all attached, or detached, log streams
+// reach EOF, or until the timeout is exceeded. It returns
+// false if the timeout is exceeded.
+func (s *logStreams) wait(detached bool, timeout time.Duration) bool {
+	var pending []chan struct{}
+	s.Lock()
+	for _, stream := range s.streams {
+		if stream.detached == detached {
+			pending = append(pending, stream.done)
+		}
+	}
+	s.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
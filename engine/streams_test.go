@@ -0,0 +1,112 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeLogs returns the pipe reader as the log stream of
+// every container.
+type fakeLogs struct {
+	reader *io.PipeReader
+}
+
+func (l *fakeLogs) Stream(namespace, name, container string) (io.ReadCloser, error) {
+	return l.reader, nil
+}
+
+func TestLogStreams(t *testing.T) {
+	attached := &Step{ID: "step1"}
+	detached := &Step{ID: "step2", Detach: true}
+
+	var logs logStreams
+	if !logs.wait(false, time.Millisecond) {
+		t.Errorf("Expect wait to return immediately when no streams are open")
+	}
+
+	logs.open(attached)
+	logs.open(detached)
+	if logs.wait(false, time.Millisecond) {
+		t.Errorf("Expect wait to time out when attached streams are open")
+	}
+
+	logs.close(attached)
+	if !logs.wait(false, time.Millisecond) {
+		t.Errorf("Expect wait to return when attached streams are closed")
+	}
+	if logs.wait(true, time.Millisecond) {
+		t.Errorf("Expect wait to time out when detached streams are open")
+	}
+
+	go logs.close(detached)
+	if !logs.wait(true, time.Second) {
+		t.Errorf("Expect wait to return when detached streams are closed")
+	}
+}
+
+// This test verifies the log stream is complete once it
+// reaches EOF, before the step returns.
+func TestTail(t *testing.T) {
+	reader, writer := io.Pipe()
+	engine := &Kubernetes{logs: &fakeLogs{reader: reader}}
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-test", Namespace: "default"}}
+	step := &Step{ID: "drone-build"}
+	spec.logs.open(step)
+
+	go func() {
+		io.WriteString(writer, "hello world\n")
+		writer.Close()
+	}()
+
+	var buf bytes.Buffer
+	if err := engine.tail(context.Background(), spec, step, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "hello world\n"; got != want {
+		t.Errorf("Want logs %q, got %q", want, got)
+	}
+	if !spec.logs.wait(false, time.Millisecond) {
+		t.Errorf("Expect log stream complete at EOF")
+	}
+}
+
+// This test verifies the log stream of a cancelled step is
+// closed, even though the stream never reaches EOF.
+func TestTail_Cancel(t *testing.T) {
+	defer func(delay time.Duration) {
+		streamCloseDelay = delay
+	}(streamCloseDelay)
+	streamCloseDelay = time.Millisecond
+
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	engine := &Kubernetes{logs: &fakeLogs{reader: reader}}
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-test", Namespace: "default"}}
+	step := &Step{ID: "drone-build"}
+	spec.logs.open(step)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		io.WriteString(writer, "stopping\n")
+		cancel()
+	}()
+
+	var buf bytes.Buffer
+	err := engine.tail(ctx, spec, step, &buf)
+	if err != context.Canceled {
+		t.Errorf("Expect context canceled error, got %v", err)
+	}
+	if got, want := buf.String(), "stopping\n"; got != want {
+		t.Errorf("Want logs %q, got %q", want, got)
+	}
+	if !spec.logs.wait(false, time.Millisecond) {
+		t.Errorf("Expect log stream complete once closed")
+	}
+}
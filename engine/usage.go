@@ -0,0 +1,144 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// metricsClient returns the resource usage of the pod
+// containers, keyed by container name, from the metrics api.
+type metricsClient interface {
+	PodMetrics(namespace, name string) (map[string]v1.ResourceList, error)
+}
+
+// restMetrics implements a metrics client that reads the pod
+// metrics from the metrics.k8s.io api.
+type restMetrics struct {
+	client rest.Interface
+}
+
+// podMetrics is the subset of the metrics.k8s.io PodMetrics
+// resource used to read the container usage.
+type podMetrics struct {
+	Containers []struct {
+		Name  string          `json:"name"`
+		Usage v1.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+// PodMetrics returns the resource usage of the pod containers.
+func (m *restMetrics) PodMetrics(namespace, name string) (map[string]v1.ResourceList, error) {
+	raw, err := m.client.Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1", "namespaces", namespace, "pods", name).
+		Do().
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+	metrics := new(podMetrics)
+	if err := json.Unmarshal(raw, metrics); err != nil {
+		return nil, err
+	}
+	usage := map[string]v1.ResourceList{}
+	for _, container := range metrics.Containers {
+		usage[container.Name] = container.Usage
+	}
+	return usage, nil
+}
+
+// usageSampler periodically samples the resource usage of a
+// step container, and records the peak and average usage.
+type usageSampler struct {
+	mu    sync.Mutex
+	usage pipeline.Usage
+	cpu   int64
+	mem   int64
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+// helper function starts sampling the resource usage of the
+// step container. A nil sampler is returned if sampling is
+// disabled.
+func (k *Kubernetes) sampleUsage(spec *Spec, step *Step) *usageSampler {
+	if k.metrics == nil || k.UsageInterval <= 0 || step.Init {
+		return nil
+	}
+	s := &usageSampler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	namespace, name := spec.PodSpec.Namespace, podName(spec, step)
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(k.UsageInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+			// the metrics api may be unavailable, or may not
+			// yet report the container, in which case the
+			// sample is skipped.
+			containers, err := k.metrics.PodMetrics(namespace, name)
+			if err != nil {
+				continue
+			}
+			if usage, ok := containers[step.ID]; ok {
+				s.record(usage.Cpu().MilliValue(), usage.Memory().Value())
+			}
+		}
+	}()
+	return s
+}
+
+// record records a usage sample.
+func (s *usageSampler) record(cpu, mem int64) {
+	s.mu.Lock()
+	s.usage.Samples++
+	s.cpu += cpu
+	s.mem += mem
+	if cpu > s.usage.CPUPeak {
+		s.usage.CPUPeak = cpu
+	}
+	if mem > s.usage.MemoryPeak {
+		s.usage.MemoryPeak = mem
+	}
+	s.mu.Unlock()
+}
+
+// finish stops sampling and returns the resource usage, or
+// nil if no samples were recorded.
+func (s *usageSampler) finish() *pipeline.Usage {
+	if s == nil {
+		return nil
+	}
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage.Samples == 0 {
+		return nil
+	}
+	usage := s.usage
+	usage.CPUAverage = s.cpu / int64(usage.Samples)
+	usage.MemoryAverage = s.mem / int64(usage.Samples)
+	return &usage
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// fakeMetrics returns the samples in order, and then returns
+// an error once the samples are exhausted.
+type fakeMetrics struct {
+	sync.Mutex
+	container string
+	samples   []v1.ResourceList
+	calls     int
+}
+
+func (m *fakeMetrics) PodMetrics(namespace, name string) (map[string]v1.ResourceList, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls++
+	if len(m.samples) == 0 {
+		return nil, errors.New("not found")
+	}
+	sample := m.samples[0]
+	m.samples = m.samples[1:]
+	return map[string]v1.ResourceList{m.container: sample}, nil
+}
+
+func (m *fakeMetrics) exhausted() bool {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.samples) == 0
+}
+
+func TestSampleUsage(t *testing.T) {
+	metrics := &fakeMetrics{
+		container: "drone-build",
+		samples: []v1.ResourceList{
+			{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("100Mi"),
+			},
+			{
+				v1.ResourceCPU:    resource.MustParse("300m"),
+				v1.ResourceMemory: resource.MustParse("300Mi"),
+			},
+		},
+	}
+	engine := &Kubernetes{
+		metrics:       metrics,
+		UsageInterval: time.Millisecond,
+	}
+	spec := &Spec{PodSpec: PodSpec{Name: "drone-test", Namespace: "default"}}
+	step := &Step{ID: "drone-build", Name: "build"}
+
+	sampler := engine.sampleUsage(spec, step)
+	for !metrics.exhausted() {
+		time.Sleep(time.Millisecond)
+	}
+	usage := sampler.finish()
+	if usage == nil {
+		t.Fatalf("Expect resource usage")
+	}
+	if got, want := usage.Samples, 2; got != want {
+		t.Errorf("Want %d samples, got %d", want, got)
+	}
+	if got, want := usage.CPUPeak, int64(300); got != want {
+		t.Errorf("Want cpu peak %d, got %d", want, got)
+	}
+	if got, want := usage.CPUAverage, int64(200); got != want {
+		t.Errorf("Want cpu average %d, got %d", want, got)
+	}
+	if got, want := usage.MemoryPeak, int64(300*1024*1024); got != want {
+		t.Errorf("Want memory peak %d, got %d", want, got)
+	}
+	if got, want := usage.MemoryAverage, int64(200*1024*1024); got != want {
+		t.Errorf("Want memory average %d, got %d", want, got)
+	}
+}
+
+func TestSampleUsage_Disabled(t *testing.T) {
+	engine := &Kubernetes{metrics: &fakeMetrics{}}
+	sampler := engine.sampleUsage(&Spec{}, &Step{ID: "drone-build"})
+	if sampler != nil {
+		t.Errorf("Expect sampling disabled without an interval")
+	}
+	if sampler.finish() != nil {
+		t.Errorf("Expect no resource usage")
+	}
+}
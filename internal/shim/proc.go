@@ -0,0 +1,30 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package shim
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// helper function configures the command to run in its own
+// process group, so that signals are delivered to the child
+// processes of the command, for example, the commands run
+// by a shell script.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// helper function sends the signal to the command process
+// group.
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok {
+		return syscall.Kill(-cmd.Process.Pid, s)
+	}
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,18 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build windows
+
+package shim
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,210 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package shim provides the step entrypoint shim. The shim
+// blocks the step container until the runner writes the start
+// signal, then runs the step command and writes the exit code.
+//
+// The signal file is a downward api volume, which the kubelet
+// updates on its own sync period, so a signal is observed up
+// to the sync period after it is written, regardless of the
+// polling interval.
+package shim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Signals written to the signal file by the runner.
+const (
+	SignalStart = "start"
+	SignalKill  = "kill"
+)
+
+// exit codes returned if the command cannot be started, or
+// is killed before it is started, following shell
+// conventions.
+const (
+	exitNotFound = 127
+	exitKilled   = 128 + int(syscall.SIGTERM)
+)
+
+// Config configures the shim.
+type Config struct {
+	// Signal is the path of the file the runner writes the
+	// start and kill signals to.
+	Signal string
+
+	// Exit is the optional path of the file the exit code is
+	// written to.
+	Exit string
+
+	// Interval is the interval the signal file is polled. The
+	// file is updated by the kubelet, so a short interval
+	// does not reduce the signal latency below the kubelet
+	// sync period.
+	Interval time.Duration
+
+	// Grace is the duration the command is given to exit
+	// after the termination signal, before it is killed. If
+	// zero, the command is never killed.
+	Grace time.Duration
+
+	// Args is the step command and arguments.
+	Args []string
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run waits for the start signal, runs the step command, and
+// returns the exit code. The command is terminated if the
+// kill signal is received, or if the shim is terminated.
+func Run(ctx context.Context, config Config) int {
+	code := run(ctx, config)
+	if config.Exit != "" {
+		ioutil.WriteFile(config.Exit, []byte(strconv.Itoa(code)), 0644)
+	}
+	return code
+}
+
+func run(ctx context.Context, config Config) int {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(terminate)
+
+	// wait for the start signal. If the container is
+	// terminated before the step starts, the command
+	// is never started.
+	for {
+		switch readSignal(config.Signal) {
+		case SignalStart:
+		case SignalKill:
+			return exitKilled
+		default:
+			select {
+			case <-ctx.Done():
+				return exitKilled
+			case <-terminate:
+				return exitKilled
+			case <-ticker.C:
+			}
+			continue
+		}
+		break
+	}
+
+	if len(config.Args) == 0 {
+		return exitNotFound
+	}
+	cmd := exec.Command(config.Args[0], config.Args[1:]...)
+	cmd.Stdin = config.Stdin
+	cmd.Stdout = config.Stdout
+	cmd.Stderr = config.Stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		io.WriteString(config.Stderr, err.Error()+"\n")
+		return exitNotFound
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	// the command is terminated if the kill signal is
+	// received, and the termination signals are forwarded
+	// to the command. If the command does not exit within
+	// the grace period, it is killed.
+	var (
+		killed  bool
+		grace   <-chan time.Time
+		stopped = ctx.Done()
+	)
+	kill := func(sig os.Signal) {
+		signalProcess(cmd, sig)
+		if grace == nil && config.Grace > 0 {
+			grace = time.After(config.Grace)
+		}
+	}
+	for {
+		select {
+		case err := <-done:
+			return exitCode(cmd, err)
+		case sig := <-terminate:
+			kill(sig)
+		case <-stopped:
+			stopped = nil
+			kill(syscall.SIGTERM)
+		case <-grace:
+			signalProcess(cmd, syscall.SIGKILL)
+		case <-ticker.C:
+			if !killed && readSignal(config.Signal) == SignalKill {
+				killed = true
+				kill(syscall.SIGTERM)
+			}
+		}
+	}
+}
+
+// helper function returns the signal written to the signal
+// file, or an empty string if no signal is written.
+func readSignal(path string) string {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(string(raw)), `"`)
+}
+
+// helper function returns the command exit code. If the
+// command is terminated by a signal, the exit code is 128
+// plus the signal number.
+func exitCode(cmd *exec.Cmd, err error) int {
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return exitNotFound
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// Install copies the shim executable to the destination
+// path, so that it can be executed from the step containers.
+func Install(dest string) error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
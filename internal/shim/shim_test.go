@@ -0,0 +1,112 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package shim
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testConfig(t *testing.T, args ...string) (Config, func()) {
+	dir, err := ioutil.TempDir("", "drone-shim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Signal:   filepath.Join(dir, "signal"),
+		Exit:     filepath.Join(dir, "exit"),
+		Interval: 10 * time.Millisecond,
+		Args:     args,
+		Stdout:   new(bytes.Buffer),
+		Stderr:   new(bytes.Buffer),
+	}
+	return config, func() { os.RemoveAll(dir) }
+}
+
+func TestRun(t *testing.T) {
+	config, cleanup := testConfig(t, "/bin/sh", "-c", "echo hello; exit 3")
+	defer cleanup()
+
+	// the command is not started until the start signal
+	// is written to the signal file.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(config.Signal, []byte(SignalStart), 0644)
+	}()
+
+	if got, want := Run(context.Background(), config), 3; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := config.Stdout.(*bytes.Buffer).String(), "hello\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+	raw, _ := ioutil.ReadFile(config.Exit)
+	if got, want := string(raw), "3"; got != want {
+		t.Errorf("Want exit file %q, got %q", want, got)
+	}
+}
+
+func TestRun_Kill(t *testing.T) {
+	config, cleanup := testConfig(t, "/bin/sh", "-c", "sleep 10")
+	defer cleanup()
+
+	ioutil.WriteFile(config.Signal, []byte(SignalStart), 0644)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(config.Signal, []byte(SignalKill), 0644)
+	}()
+
+	if got, want := Run(context.Background(), config), exitKilled; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+}
+
+func TestRun_KillGrace(t *testing.T) {
+	config, cleanup := testConfig(t, "/bin/sh", "-c", "trap '' TERM; sleep 10")
+	defer cleanup()
+	config.Grace = 100 * time.Millisecond
+
+	// the command ignores the termination signal, and is
+	// killed once the grace period expires.
+	ioutil.WriteFile(config.Signal, []byte(SignalStart), 0644)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(config.Signal, []byte(SignalKill), 0644)
+	}()
+
+	if got, want := Run(context.Background(), config), 137; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+}
+
+func TestRun_KillBeforeStart(t *testing.T) {
+	config, cleanup := testConfig(t, "/bin/sh", "-c", "echo hello")
+	defer cleanup()
+
+	ioutil.WriteFile(config.Signal, []byte(SignalKill), 0644)
+	if got, want := Run(context.Background(), config), exitKilled; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got := config.Stdout.(*bytes.Buffer).String(); got != "" {
+		t.Errorf("Want command not started, got output %q", got)
+	}
+}
+
+func TestRun_NotFound(t *testing.T) {
+	config, cleanup := testConfig(t, "/bin/not-found")
+	defer cleanup()
+
+	ioutil.WriteFile(config.Signal, []byte(SignalStart), 0644)
+	if got, want := Run(context.Background(), config), exitNotFound; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+}
@@ -20,6 +20,10 @@ import (
 // from the git ref (e.g. refs/pulls/{d}/head)
 var re = regexp.MustCompile(`\d+`)
 
+// regular expression to match characters that are not valid
+// in environment variable names.
+var reInvalid = regexp.MustCompile(`[^A-Z0-9_]`)
+
 // System returns a set of environment variables containing
 // system metadata.
 func System(system *drone.System) map[string]string {
@@ -110,6 +114,23 @@ func StepArgs(name string, number int64) map[string]string {
 	}
 }
 
+// Outputs returns a set of environment variables containing
+// the outputs of the named step.
+func Outputs(name string, outputs map[string]string) map[string]string {
+	env := map[string]string{}
+	for k, v := range outputs {
+		key := OutputPrefix(name) + envName(k)
+		env[key] = v
+	}
+	return env
+}
+
+// OutputPrefix returns the prefix of the environment variables
+// containing the outputs of the named step.
+func OutputPrefix(name string) string {
+	return "DRONE_OUTPUT_" + envName(name) + "_"
+}
+
 // Build returns a set of environment variables containing
 // build metadata.
 func Build(build *drone.Build) map[string]string {
@@ -238,6 +259,12 @@ func Slice(env map[string]string) []string {
 	return s
 }
 
+// helper function converts the name to a valid environment
+// variable name.
+func envName(name string) string {
+	return reInvalid.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
 // copyenv copies environment variables from the source map
 // to the destination map.
 func copyenv(src, dst map[string]string) {
@@ -213,6 +213,21 @@ func TestStep(t *testing.T) {
 	}
 }
 
+func TestOutputs(t *testing.T) {
+	a := Outputs("go-build", map[string]string{
+		"version":   "1.2.3",
+		"image.tag": "latest",
+	})
+	b := map[string]string{
+		"DRONE_OUTPUT_GO_BUILD_VERSION":   "1.2.3",
+		"DRONE_OUTPUT_GO_BUILD_IMAGE_TAG": "latest",
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Fail()
+		t.Log(diff)
+	}
+}
+
 func TestStage(t *testing.T) {
 	v := &drone.Stage{
 		Kind:      "pipeline",
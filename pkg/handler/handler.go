@@ -7,11 +7,14 @@
 package handler
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 	"sort"
 	"strconv"
 
 	hook "github.com/ozonep/drone-runner-kube/pkg/logger/history"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
 	"github.com/ozonep/drone-runner-kube/pkg/pipeline/reporter/history"
 	"github.com/ozonep/drone/pkg/drone"
 )
@@ -89,6 +92,69 @@ func HandleLogHistory(t *hook.Hook) http.HandlerFunc {
 	}
 }
 
+// HandleMetrics returns a http.HandlerFunc that exposes the
+// resource usage of recently executed pipeline steps in the
+// prometheus text format.
+func HandleMetrics(t *history.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries := t.Entries()
+		sort.Sort(history.ByTimestamp(entries))
+
+		nocache(w)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, metric := range usageMetrics {
+			fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+			fmt.Fprintf(w, "# TYPE %s gauge\n", metric.name)
+			for _, entry := range entries {
+				for _, usage := range entry.Usages {
+					writeMetric(w, metric.name, entry, usage.Step, metric.value(usage))
+				}
+			}
+		}
+	}
+}
+
+// usageMetrics defines the gauges exposed for each step.
+var usageMetrics = []struct {
+	name  string
+	help  string
+	value func(*pipeline.Usage) int64
+}{
+	{
+		name:  "drone_step_cpu_peak_millicores",
+		help:  "Peak cpu usage of the pipeline step.",
+		value: func(u *pipeline.Usage) int64 { return u.CPUPeak },
+	},
+	{
+		name:  "drone_step_cpu_average_millicores",
+		help:  "Average cpu usage of the pipeline step.",
+		value: func(u *pipeline.Usage) int64 { return u.CPUAverage },
+	},
+	{
+		name:  "drone_step_memory_peak_bytes",
+		help:  "Peak memory usage of the pipeline step.",
+		value: func(u *pipeline.Usage) int64 { return u.MemoryPeak },
+	},
+	{
+		name:  "drone_step_memory_average_bytes",
+		help:  "Average memory usage of the pipeline step.",
+		value: func(u *pipeline.Usage) int64 { return u.MemoryAverage },
+	},
+}
+
+// helper function writes a single metric sample labeled with
+// the repository, build, stage and step.
+func writeMetric(w io.Writer, name string, entry *history.Entry, step string, value int64) {
+	fmt.Fprintf(w, "%s{repo=%q,build=\"%d\",stage=%q,step=%q} %d\n",
+		name,
+		entry.Repo.Slug,
+		entry.Build.Number,
+		entry.Stage.Name,
+		step,
+		value,
+	)
+}
+
 // data is a template data structure that provides helper
 // functions for calculating the system state.
 type data struct {
@@ -3,3 +3,57 @@
 // that can be found in the LICENSE file.
 
 package handler
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline/reporter/history"
+	"github.com/ozonep/drone/pkg/drone"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	state := &pipeline.State{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{Number: 42},
+		Stage: &drone.Stage{
+			ID:    1,
+			Name:  "default",
+			Steps: []*drone.Step{{Name: "build"}},
+		},
+	}
+	state.SetUsage("build", &pipeline.Usage{
+		Samples:       2,
+		CPUPeak:       300,
+		CPUAverage:    200,
+		MemoryPeak:    2048,
+		MemoryAverage: 1024,
+	})
+
+	tracer := history.New(pipeline.NopReporter())
+	tracer.ReportStage(context.Background(), state)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	HandleMetrics(tracer).ServeHTTP(w, r)
+
+	if got, want := w.Code, 200; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE drone_step_cpu_peak_millicores gauge\n",
+		`drone_step_cpu_peak_millicores{repo="octocat/hello-world",build="42",stage="default",step="build"} 300`,
+		`drone_step_cpu_average_millicores{repo="octocat/hello-world",build="42",stage="default",step="build"} 200`,
+		`drone_step_memory_peak_bytes{repo="octocat/hello-world",build="42",stage="default",step="build"} 2048`,
+		`drone_step_memory_average_bytes{repo="octocat/hello-world",build="42",stage="default",step="build"} 1024`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Want metrics to include %q", want)
+		}
+	}
+}
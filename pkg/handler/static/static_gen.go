@@ -228,7 +228,7 @@ var files = map[string]file{
 		data: file11,
 		FileInfo: &fileInfo{
 			name:    "style.css",
-			size:    8564,
+			size:    9740,
 			modTime: time.Unix(1572549830, 0),
 		},
 	},
@@ -1620,6 +1620,83 @@ a.card {
     margin: 0px 3px;
 }
 
+/*
+ * summary card component
+ */
+
+.summaries .summary {
+    padding: 15px;
+}
+
+.summaries .summary + .summary {
+    border-top: 1px solid rgba(30,55,90,.1);
+}
+
+.summaries .summary .name {
+    font-weight: bold;
+}
+
+.summaries .markdown h1,
+.summaries .markdown h2,
+.summaries .markdown h3,
+.summaries .markdown h4,
+.summaries .markdown h5,
+.summaries .markdown h6 {
+    font-weight: bold;
+    margin: 10px 0px;
+}
+
+.summaries .markdown p,
+.summaries .markdown ul,
+.summaries .markdown pre {
+    margin: 10px 0px;
+}
+
+.summaries .markdown ul {
+    list-style: disc;
+    padding-left: 20px;
+}
+
+.summaries .markdown pre,
+.summaries .markdown code {
+    font-family: var(--font-mono);
+}
+
+.summaries .markdown pre {
+    background: #f8f8fa;
+    overflow-x: auto;
+    padding: 10px;
+}
+
+/*
+ * usage card component
+ */
+
+.usages .usage {
+    display: flex;
+    padding: 10px 15px;
+}
+
+.usages .usage + .usage {
+    border-top: 1px solid rgba(30,55,90,.1);
+}
+
+.usages .usage span {
+    flex: 1;
+    font-family: var(--font-mono);
+}
+
+.usages .usage .name {
+    flex: 2;
+    font-family: inherit;
+    font-weight: bold;
+}
+
+.usages .header span {
+    font-family: inherit;
+    opacity: 0.7;
+}
+
 /*
  * animations
  */
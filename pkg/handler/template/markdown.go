@@ -0,0 +1,118 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// markdown renders a subset of markdown, written by pipeline
+// steps, to html. Headings, lists, code blocks and paragraphs
+// are supported, and all text is escaped.
+func markdown(s string) template.HTML {
+	var (
+		buf   = new(bytes.Buffer)
+		para  []string
+		list  bool
+		fence bool
+	)
+
+	// helper function closes the open paragraph or list.
+	flush := func() {
+		if len(para) != 0 {
+			fmt.Fprintf(buf, "<p>%s</p>\n", inline(strings.Join(para, " ")))
+			para = nil
+		}
+		if list {
+			buf.WriteString("</ul>\n")
+			list = false
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			if fence {
+				buf.WriteString("</code></pre>\n")
+			} else {
+				flush()
+				buf.WriteString("<pre><code>")
+			}
+			fence = !fence
+		case fence:
+			buf.WriteString(template.HTMLEscapeString(line))
+			buf.WriteString("\n")
+		case trimmed == "":
+			flush()
+		case heading(trimmed) != 0:
+			flush()
+			level := heading(trimmed)
+			text := strings.TrimSpace(trimmed[level:])
+			fmt.Fprintf(buf, "<h%d>%s</h%d>\n", level, inline(text), level)
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			if !list {
+				flush()
+				buf.WriteString("<ul>\n")
+				list = true
+			}
+			fmt.Fprintf(buf, "<li>%s</li>\n", inline(trimmed[2:]))
+		default:
+			if list {
+				flush()
+			}
+			para = append(para, trimmed)
+		}
+	}
+	if fence {
+		buf.WriteString("</code></pre>\n")
+	}
+	flush()
+	return template.HTML(buf.String())
+}
+
+// helper function returns the heading level of the line, or
+// zero if the line is not a heading.
+func heading(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+// helper function escapes the text, and renders inline code
+// spans.
+func inline(s string) string {
+	parts := strings.Split(s, "`")
+	for i, part := range parts {
+		parts[i] = template.HTMLEscapeString(part)
+	}
+	// an unmatched backtick is rendered as text.
+	if len(parts)%2 == 0 {
+		last := len(parts) - 1
+		parts[last-1] = parts[last-1] + "`" + parts[last]
+		parts = parts[:last]
+	}
+	buf := new(strings.Builder)
+	for i, part := range parts {
+		if i%2 == 1 {
+			fmt.Fprintf(buf, "<code>%s</code>", part)
+		} else {
+			buf.WriteString(part)
+		}
+	}
+	return buf.String()
+}
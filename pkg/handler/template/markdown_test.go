@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package template
+
+import (
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		text string
+		html string
+	}{
+		{
+			text: "## Coverage",
+			html: "<h2>Coverage</h2>\n",
+		},
+		{
+			text: "line one\nline two\n\nline three",
+			html: "<p>line one line two</p>\n<p>line three</p>\n",
+		},
+		{
+			text: "- one\n- `two`",
+			html: "<ul>\n<li>one</li>\n<li><code>two</code></li>\n</ul>\n",
+		},
+		{
+			text: "```\n<b>code</b>\n```",
+			html: "<pre><code>&lt;b&gt;code&lt;/b&gt;\n</code></pre>\n",
+		},
+		{
+			text: "<script>alert(1)</script>",
+			html: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		},
+		{
+			text: "#hashtag",
+			html: "<p>#hashtag</p>\n",
+		},
+	}
+	for _, test := range tests {
+		if got, want := string(markdown(test.text)), test.html; got != want {
+			t.Errorf("Want html %q, got %q", want, got)
+		}
+	}
+}
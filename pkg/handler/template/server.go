@@ -8,6 +8,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
@@ -16,6 +17,8 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/docker/go-units"
 )
 
 func main() {
@@ -106,4 +109,10 @@ var funcMap = map[string]interface{}{
 	"done": func(s string) bool {
 		return s != "pending" && s != "running"
 	},
+	"cpu": func(v float64) string {
+		return fmt.Sprintf("%dm", int64(v))
+	},
+	"bytes": func(v float64) string {
+		return units.BytesSize(v)
+	},
 }
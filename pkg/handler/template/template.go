@@ -5,9 +5,12 @@
 package template
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/docker/go-units"
 )
 
 //go:generate togo tmpl -func funcMap -format html
@@ -36,4 +39,11 @@ var funcMap = map[string]interface{}{
 	"done": func(s string) bool {
 		return s != "pending" && s != "running"
 	},
+	"markdown": markdown,
+	"cpu": func(v int64) string {
+		return fmt.Sprintf("%dm", v)
+	},
+	"bytes": func(v int64) string {
+		return units.BytesSize(float64(v))
+	},
 }
@@ -241,6 +241,38 @@ var stage = `<!DOCTYPE html>
         </div>
         {{ end }}
 
+        {{ if .Summaries }}
+        <div class="card summaries">
+            {{ range .Summaries }}
+            <div class="summary">
+                <span class="name">{{ .Step }}</span>
+                <div class="markdown">{{ markdown .Markdown }}</div>
+            </div>
+            {{ end }}
+        </div>
+        {{ end }}
+
+        {{ if .Usages }}
+        <div class="card usages">
+            <div class="usage header">
+                <span class="name">Step</span>
+                <span>CPU peak</span>
+                <span>CPU average</span>
+                <span>Memory peak</span>
+                <span>Memory average</span>
+            </div>
+            {{ range .Usages }}
+            <div class="usage">
+                <span class="name">{{ .Step }}</span>
+                <span>{{ cpu .CPUPeak }}</span>
+                <span>{{ cpu .CPUAverage }}</span>
+                <span>{{ bytes .MemoryPeak }}</span>
+                <span>{{ bytes .MemoryAverage }}</span>
+            </div>
+            {{ end }}
+        </div>
+        {{ end }}
+
         {{ if .Logs }}
         <div class="logs">
             {{ range .Logs }}
@@ -7,16 +7,19 @@ package history
 import (
 	"time"
 
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
 	"github.com/ozonep/drone/pkg/drone"
 )
 
 // Entry represents a history entry.
 type Entry struct {
-	Stage   *drone.Stage `json:"stage"`
-	Build   *drone.Build `json:"build"`
-	Repo    *drone.Repo  `json:"repo"`
-	Created time.Time    `json:"created"`
-	Updated time.Time    `json:"updated"`
+	Stage     *drone.Stage        `json:"stage"`
+	Build     *drone.Build        `json:"build"`
+	Repo      *drone.Repo         `json:"repo"`
+	Summaries []*pipeline.Summary `json:"summaries,omitempty"`
+	Usages    []*pipeline.Usage   `json:"usages,omitempty"`
+	Created   time.Time           `json:"created"`
+	Updated   time.Time           `json:"updated"`
 }
 
 // ByTimestamp sorts a list of entries by timestamp
@@ -94,16 +94,20 @@ func (h *History) update(state *pipeline.State) {
 			v.Stage = internal.CloneStage(state.Stage)
 			v.Build = internal.CloneBuild(state.Build)
 			v.Repo = internal.CloneRepo(state.Repo)
+			v.Summaries = state.Summaries()
+			v.Usages = state.Usages()
 			v.Updated = time.Now().UTC()
 			return
 		}
 	}
 	h.items = append(h.items, &Entry{
-		Stage:   internal.CloneStage(state.Stage),
-		Build:   internal.CloneBuild(state.Build),
-		Repo:    internal.CloneRepo(state.Repo),
-		Created: time.Now(),
-		Updated: time.Now(),
+		Stage:     internal.CloneStage(state.Stage),
+		Build:     internal.CloneBuild(state.Build),
+		Repo:      internal.CloneRepo(state.Repo),
+		Summaries: state.Summaries(),
+		Usages:    state.Usages(),
+		Created:   time.Now(),
+		Updated:   time.Now(),
 	})
 }
 
@@ -7,18 +7,38 @@ package runtime
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/ozonep/drone-runner-kube/pkg/environ"
 	"github.com/ozonep/drone-runner-kube/pkg/logger"
 	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
 	"github.com/ozonep/drone/pkg/drone"
 
+	"github.com/docker/go-units"
 	"github.com/hashicorp/go-multierror"
 	"github.com/natessilva/dag"
 	"golang.org/x/sync/semaphore"
 )
 
+// defaultCleanupTimeout is the default maximum duration the
+// steps that run when the pipeline is cancelled are given to
+// complete.
+const defaultCleanupTimeout = 10 * time.Minute
+
+// ErrDisrupted is returned when the pipeline environment is
+// disrupted, for example, because the pipeline pod is evicted
+// or preempted. If the stage can be retried, it is returned by
+// Exec before the stage is reported, so the stage can be re-run
+// from scratch.
+var ErrDisrupted = errors.New("the pipeline environment was disrupted")
+
+// errNotReady is returned when a detached step exits, or
+// fails to start, before it is ready.
+var errNotReady = errors.New("the step exited before it was ready")
+
 // Execer executes the pipeline.
 type Execer struct {
 	mu       sync.Mutex
@@ -26,6 +46,10 @@ type Execer struct {
 	reporter pipeline.Reporter
 	streamer pipeline.Streamer
 	sem      *semaphore.Weighted
+
+	// CleanupTimeout is the maximum duration the steps that
+	// run when the pipeline is cancelled are given to complete.
+	CleanupTimeout time.Duration
 }
 
 // NewExecer returns a new execer.
@@ -83,8 +107,11 @@ func (e *Execer) Exec(ctx context.Context, spec Spec, state *pipeline.State) err
 			err := e.exec(ctx, state, spec, step)
 			// if the step is configured to fast fail the
 			// pipeline, and if the step returned a non-zero
-			// exit code, cancel the entire pipeline.
-			if step.GetErrPolicy() == ErrFailFast {
+			// exit code, cancel the entire pipeline. If the
+			// pipeline environment is disrupted, the remaining
+			// steps are not started, and the pipeline is not
+			// cancelled, so the stage can be retried.
+			if step.GetErrPolicy() == ErrFailFast && !state.Disrupted() {
 				step := state.Find(step.GetName())
 				// reading data from the step is not thread
 				// safe so we need to acquire a lock.
@@ -122,6 +149,21 @@ func (e *Execer) Exec(ctx context.Context, spec Spec, state *pipeline.State) err
 		}
 	}
 
+	// if the pipeline environment was disrupted, and the stage
+	// can be retried, the stage is not reported, so that it can
+	// be re-run from scratch. Otherwise the disrupted steps,
+	// which are not reported when they fail, are reported.
+	if state.Disrupted() {
+		if state.Retries > 0 && !state.Cancelled() {
+			return ErrDisrupted
+		}
+		for _, name := range state.DisruptedSteps() {
+			if err := e.reporter.ReportStep(noContext, state, name); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+
 	// once pipeline execution completes, notify the state
 	// manager that all steps are finished.
 	state.FinishAll()
@@ -134,17 +176,25 @@ func (e *Execer) Exec(ctx context.Context, spec Spec, state *pipeline.State) err
 func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, step Step) error {
 	var result error
 
-	select {
-	case <-ctx.Done():
-		state.Cancel()
-		return nil
-	default:
-	}
-
 	log := logger.FromContext(ctx)
 	log = log.WithField("step.name", step.GetName())
 	ctx = logger.WithContext(ctx, log)
 
+	// if the pipeline is cancelled, only the steps that run
+	// on failure, or on cancellation, are executed. They run
+	// with a new context, so they can clean up.
+	cleanup := false
+	if ctx.Err() != nil {
+		state.Cancel()
+		if !isCleanup(step) {
+			return nil
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = e.cleanupContext(ctx)
+		defer cancel()
+		cleanup = true
+	}
+
 	if e.sem != nil {
 		log.Trace("acquiring semaphore")
 		// the semaphore limits the number of steps that can run
@@ -154,7 +204,17 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 
 		// if acquiring the semaphore failed because the context
 		// deadline exceeded (e.g. the pipeline timed out) the
-		// state should be canceled.
+		// state should be canceled. The steps that run on
+		// cancellation acquire the semaphore with a new context.
+		if err != nil && ctx.Err() != nil && !cleanup && isCleanup(step) {
+			log.Trace("acquiring semaphore canceled, cleaning up")
+			state.Cancel()
+			var cancel context.CancelFunc
+			ctx, cancel = e.cleanupContext(ctx)
+			defer cancel()
+			cleanup = true
+			err = e.sem.Acquire(ctx, 1)
+		}
 		if errors.Is(ctx.Err(), context.Canceled) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
 			log.Trace("acquiring semaphore canceled")
 			state.Cancel()
@@ -179,10 +239,16 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 	}
 
 	switch {
-	case state.Cancelled():
+	case state.Disrupted():
+		return nil
+	case state.Cancelled() && !cleanup:
 		return nil
 	case step.GetRunPolicy() == RunNever:
 		return nil
+	case cleanup && state.Finished(step.GetName()):
+		return nil
+	case cleanup:
+		break
 	case step.GetRunPolicy() == RunAlways:
 		break
 	case step.GetRunPolicy() == RunOnFailure && !state.Failed():
@@ -216,6 +282,17 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 	)
 	state.Unlock()
 
+	// the outputs written by the step dependencies are exposed
+	// to the step as environment variables.
+	for _, name := range dependencies(spec, step.GetName()) {
+		copy.SetEnviron(
+			environ.Combine(
+				copy.GetEnviron(),
+				environ.Outputs(name, state.Outputs(name)),
+			),
+		)
+	}
+
 	// writer used to stream build logs.
 	wc := e.streamer.Stream(noContext, state, step.GetName())
 	wc = newReplacer(wc, secretSlice(step))
@@ -223,15 +300,71 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 	// if the step is configured as a daemon, it is detached
 	// from the main process and executed separately.
 	if step.IsDetached() {
+		// the readiness check is cancelled if the step exits,
+		// or fails to start.
+		ctxready, cancel := context.WithCancel(ctx)
+		defer cancel()
+
 		go func() {
 			e.engine.Run(ctx, spec, copy, wc)
+			cancel()
 			wc.Close()
 		}()
-		return nil
+
+		// if the engine supports readiness checks, dependent
+		// steps are blocked until the detached step is ready.
+		waiter, ok := e.engine.(Waiter)
+		if !ok {
+			return nil
+		}
+		err := waiter.WaitReady(ctxready, spec, copy)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(ctx.Err(), context.Canceled) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			state.Cancel()
+			return nil
+		}
+		if errors.Is(err, context.Canceled) {
+			err = errNotReady
+		}
+		log.WithError(err).Debugln("detached step is not ready")
+		fail(state, step.GetName(), err)
+		if errors.Is(err, ErrDisrupted) {
+			return nil
+		}
+		return e.reporter.ReportStep(noContext, state, step.GetName())
 	}
 
 	exited, err := e.engine.Run(ctx, spec, copy, wc)
 
+	// if the step terminated abnormally, for example it was
+	// killed for exceeding its memory limit, a footer is
+	// appended to the logs describing the reason.
+	var reason string
+	if exited != nil {
+		reason = terminationReason(exited)
+		if reason != "" {
+			io.WriteString(wc, "\n"+reason+"\n")
+		}
+		// if the resource usage of the step was sampled, a
+		// footer is appended to the logs summarizing the usage.
+		if exited.Usage != nil {
+			io.WriteString(wc, "\n"+usageSummary(exited.Usage)+"\n")
+		}
+	}
+
+	// if the step failed, and the engine supports debug mode,
+	// the pipeline environment is held so the step can be
+	// debugged interactively before it is destroyed.
+	if isDebuggable(step.GetErrPolicy(), exited) {
+		if debugger, ok := e.engine.(Debugger); ok {
+			if err := debugger.Debug(ctx, spec, copy, wc); err != nil {
+				log.WithError(err).Warnln("cannot debug the failed step")
+			}
+		}
+	}
+
 	// close the stream. If the session is a remote session, the
 	// full log buffer is uploaded to the remote server.
 	if err := wc.Close(); err != nil {
@@ -244,6 +377,10 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 	}
 
 	if exited != nil {
+		state.SetOutputs(step.GetName(), exited.Outputs, exited.Summary)
+		if exited.Usage != nil {
+			state.SetUsage(step.GetName(), exited.Usage)
+		}
 		if exited.OOMKilled {
 			log.Debugln("received oom kill.")
 			state.Finish(step.GetName(), 137)
@@ -251,6 +388,13 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 			log.Debugf("received exit code %d", exited.ExitCode)
 			state.Finish(step.GetName(), exited.ExitCode)
 		}
+		if reason != "" {
+			// writing data to the step is not thread
+			// safe so we need to acquire a lock.
+			state.Lock()
+			findStep(state, step.GetName()).Error = reason
+			state.Unlock()
+		}
 		err := e.reporter.ReportStep(noContext, state, step.GetName())
 		if err != nil {
 			log.Warnln("cannot report step status.")
@@ -272,7 +416,10 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 
 	// if the step failed with an internal error (as opposed to a
 	// runtime error) the step is failed.
-	state.Fail(step.GetName(), err)
+	fail(state, step.GetName(), err)
+	if errors.Is(err, ErrDisrupted) {
+		return result
+	}
 	err = e.reporter.ReportStep(noContext, state, step.GetName())
 	if err != nil {
 		log.Warnln("cannot report step failure.")
@@ -281,6 +428,101 @@ func (e *Execer) exec(ctx context.Context, state *pipeline.State, spec Spec, ste
 	return result
 }
 
+// helper function fails the named step. If the step failed
+// because the pipeline environment was disrupted, the pipeline
+// is marked disrupted, so the stage can be retried. The step
+// is not reported until the stage retry is decided, so the
+// step is not reported as failed before it is reset.
+func fail(state *pipeline.State, name string, err error) {
+	if errors.Is(err, ErrDisrupted) {
+		state.Disrupt(name, err)
+	} else {
+		state.Fail(name, err)
+	}
+}
+
+// helper function returns true if the step runs when the
+// pipeline is cancelled.
+func isCleanup(step Step) bool {
+	switch {
+	case step.GetRunPolicy() == RunNever:
+		return false
+	case step.GetRunPolicy() == RunOnFailure,
+		step.GetRunPolicy() == RunAlways:
+		return true
+	default:
+		return step.IsOnCancel()
+	}
+}
+
+// helper function returns a new context, with the logger of
+// the cancelled parent context, used to run the steps that
+// clean up after the pipeline is cancelled.
+func (e *Execer) cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = logger.WithContext(noContext, logger.FromContext(ctx))
+	timeout := e.CleanupTimeout
+	if timeout <= 0 {
+		timeout = defaultCleanupTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// helper function returns a message describing why the step
+// terminated abnormally. If the step exited normally, with a
+// zero or non-zero exit code, an empty string is returned.
+func terminationReason(state *State) string {
+	switch {
+	case state.OOMKilled:
+		return "step terminated: OOMKilled, the step exceeded its memory limit"
+	case state.Reason == "", state.Reason == "Completed", state.Reason == "Error":
+		return ""
+	case state.Message != "":
+		return fmt.Sprintf("step terminated: %s, %s", state.Reason, state.Message)
+	default:
+		return fmt.Sprintf("step terminated: %s", state.Reason)
+	}
+}
+
+// helper function returns a message summarizing the resource
+// usage of the step.
+func usageSummary(usage *pipeline.Usage) string {
+	return fmt.Sprintf("resource usage: cpu %dm peak, %dm average; memory %s peak, %s average",
+		usage.CPUPeak,
+		usage.CPUAverage,
+		units.BytesSize(float64(usage.MemoryPeak)),
+		units.BytesSize(float64(usage.MemoryAverage)),
+	)
+}
+
+// helper function returns the names of the steps the named
+// step depends on, directly or indirectly.
+func dependencies(spec Spec, name string) []string {
+	steps := map[string]Step{}
+	for i := 0; i < spec.StepLen(); i++ {
+		step := spec.StepAt(i)
+		steps[step.GetName()] = step
+	}
+	var names []string
+	visited := map[string]bool{name: true}
+	var visit func(name string)
+	visit = func(name string) {
+		step, ok := steps[name]
+		if !ok {
+			return
+		}
+		for _, dep := range step.GetDependencies() {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			visit(dep)
+			names = append(names, dep)
+		}
+	}
+	visit(name)
+	return names
+}
+
 // helper function returns the named step from the state.
 func findStep(state *pipeline.State, name string) *drone.Step {
 	for _, step := range state.Stage.Steps {
@@ -300,3 +542,11 @@ func secretSlice(step Step) []Secret {
 	}
 	return secrets
 }
+
+// helper function returns true if the pipeline environment
+// should be held so the failed step can be debugged. Steps
+// that are allowed to fail are not held, since the failure
+// does not fail the pipeline.
+func isDebuggable(policy ErrPolicy, exited *State) bool {
+	return exited != nil && exited.ExitCode != 0 && policy != ErrIgnore
+}
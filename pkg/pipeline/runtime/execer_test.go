@@ -4,7 +4,17 @@
 
 package runtime
 
-import "testing"
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
+	"github.com/ozonep/drone/pkg/drone"
+)
 
 func TestExec(t *testing.T) {
 	t.Skip()
@@ -33,3 +43,184 @@ func TestExec_ReportError(t *testing.T) {
 func TestExec_SkipCtxDone(t *testing.T) {
 	t.Skip()
 }
+
+func TestTerminationReason(t *testing.T) {
+	tests := []struct {
+		state *State
+		want  string
+	}{
+		{
+			state: &State{ExitCode: 0, Reason: "Completed"},
+			want:  "",
+		},
+		{
+			state: &State{ExitCode: 1, Reason: "Error"},
+			want:  "",
+		},
+		{
+			state: &State{ExitCode: 137, Reason: "OOMKilled", OOMKilled: true},
+			want:  "step terminated: OOMKilled, the step exceeded its memory limit",
+		},
+		{
+			state: &State{ExitCode: 137, Reason: "DeadlineExceeded", Message: "Pod was active too long"},
+			want:  "step terminated: DeadlineExceeded, Pod was active too long",
+		},
+	}
+	for _, test := range tests {
+		if got := terminationReason(test.state); got != test.want {
+			t.Errorf("Want reason %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestUsageSummary(t *testing.T) {
+	usage := &pipeline.Usage{
+		CPUPeak:       250,
+		CPUAverage:    120,
+		MemoryPeak:    512 * 1024 * 1024,
+		MemoryAverage: 300 * 1024 * 1024,
+	}
+	want := "resource usage: cpu 250m peak, 120m average; memory 512MiB peak, 300MiB average"
+	if got := usageSummary(usage); got != want {
+		t.Errorf("Want summary %q, got %q", want, got)
+	}
+}
+
+func TestIsDebuggable(t *testing.T) {
+	failed := &State{Exited: true, ExitCode: 1}
+	passed := &State{Exited: true}
+	tests := []struct {
+		policy ErrPolicy
+		exited *State
+		want   bool
+	}{
+		{policy: ErrFail, exited: failed, want: true},
+		{policy: ErrFail, exited: passed, want: false},
+		{policy: ErrFail, exited: nil, want: false},
+		{policy: ErrIgnore, exited: failed, want: false},
+		{policy: ErrFailFast, exited: failed, want: true},
+	}
+	for i, test := range tests {
+		if got := isDebuggable(test.policy, test.exited); got != test.want {
+			t.Errorf("Want debuggable %v at index %d, got %v", test.want, i, got)
+		}
+	}
+}
+
+// This test verifies a step that fails because the pipeline
+// environment is disrupted is not reported while the stage
+// can be retried, and is reported once the retries are
+// exhausted.
+func TestCleanupContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	e := &Execer{}
+	cleanup, cancel := e.cleanupContext(ctx)
+	defer cancel()
+	if cleanup.Err() != nil {
+		t.Errorf("Expect cleanup context not cancelled with the parent")
+	}
+	deadline, _ := cleanup.Deadline()
+	if d := time.Until(deadline); d <= 9*time.Minute || d > defaultCleanupTimeout {
+		t.Errorf("Want default cleanup timeout, got %s", d)
+	}
+
+	e.CleanupTimeout = time.Minute
+	cleanup, cancel = e.cleanupContext(ctx)
+	defer cancel()
+	deadline, _ = cleanup.Deadline()
+	if d := time.Until(deadline); d <= 0 || d > time.Minute {
+		t.Errorf("Want configured cleanup timeout, got %s", d)
+	}
+}
+
+func TestExec_Disrupted(t *testing.T) {
+	spec := &fakeSpec{steps: []*fakeStep{{name: "build"}}}
+	for _, retries := range []int{1, 0} {
+		state := &pipeline.State{
+			Build: &drone.Build{},
+			Stage: &drone.Stage{
+				Steps: []*drone.Step{{Name: "build", Status: drone.StatusPending}},
+			},
+			Retries: retries,
+		}
+		reporter := &fakeReporter{}
+		execer := NewExecer(reporter, pipeline.NopStreamer(), &fakeEngine{err: ErrDisrupted}, 0)
+		err := execer.Exec(context.Background(), spec, state)
+
+		if retries > 0 {
+			if err != ErrDisrupted {
+				t.Errorf("Want disrupted error, got %v", err)
+			}
+			if got, want := reporter.statuses, []string{drone.StatusRunning}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Want disrupted step not reported before the retry, got %v", got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Want no error once the retries are exhausted, got %v", err)
+		}
+		if got, want := reporter.statuses, []string{drone.StatusRunning, drone.StatusError}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Want disrupted step reported %v, got %v", want, got)
+		}
+	}
+}
+
+// fakeEngine is a helper engine that fails every step with
+// the configured error.
+type fakeEngine struct {
+	err error
+}
+
+func (e *fakeEngine) Setup(context.Context, Spec) error   { return nil }
+func (e *fakeEngine) Destroy(context.Context, Spec) error { return nil }
+func (e *fakeEngine) Run(context.Context, Spec, Step, io.Writer) (*State, error) {
+	return nil, e.err
+}
+
+// fakeReporter is a helper reporter that records the
+// reported step statuses.
+type fakeReporter struct {
+	sync.Mutex
+	statuses []string
+}
+
+func (r *fakeReporter) ReportStage(context.Context, *pipeline.State) error { return nil }
+func (r *fakeReporter) ReportStep(_ context.Context, state *pipeline.State, name string) error {
+	step := state.Find(name)
+	state.Lock()
+	status := step.Status
+	state.Unlock()
+	r.Lock()
+	r.statuses = append(r.statuses, status)
+	r.Unlock()
+	return nil
+}
+
+type fakeSpec struct {
+	steps []*fakeStep
+}
+
+func (s *fakeSpec) StepAt(i int) Step { return s.steps[i] }
+func (s *fakeSpec) StepLen() int      { return len(s.steps) }
+
+type fakeStep struct {
+	name    string
+	environ map[string]string
+}
+
+func (s *fakeStep) GetName() string                  { return s.name }
+func (s *fakeStep) GetDependencies() []string        { return nil }
+func (s *fakeStep) GetEnviron() map[string]string    { return s.environ }
+func (s *fakeStep) SetEnviron(env map[string]string) { s.environ = env }
+func (s *fakeStep) GetErrPolicy() ErrPolicy          { return ErrFail }
+func (s *fakeStep) GetRunPolicy() RunPolicy          { return RunOnSuccess }
+func (s *fakeStep) GetSecretAt(int) Secret           { return nil }
+func (s *fakeStep) GetSecretLen() int                { return 0 }
+func (s *fakeStep) IsDetached() bool                 { return false }
+func (s *fakeStep) IsOnCancel() bool                 { return false }
+func (s *fakeStep) Clone() Step {
+	clone := *s
+	return &clone
+}
@@ -63,6 +63,11 @@ type Runner struct {
 	// Lookup is a helper function that extracts the resource
 	// from the manifest by name.
 	Lookup func(string, *manifest.Manifest) (manifest.Resource, error)
+
+	// Retries is the number of times a stage is re-run from
+	// scratch if the pipeline environment is disrupted, for
+	// example, if the pipeline pod is evicted or preempted.
+	Retries int
 }
 
 // Run runs the pipeline stage.
@@ -189,6 +194,8 @@ func (s *Runner) run(ctx context.Context, stage *drone.Stage, data *client.Conte
 		Stage:  stage,
 		Repo:   data.Repo,
 		System: data.System,
+
+		Retries: s.Retries,
 	}
 
 	// evaluates whether or not the agent can process the
@@ -280,7 +287,7 @@ func (s *Runner) run(ctx context.Context, stage *drone.Stage, data *client.Conte
 	log.Debug("updated stage to running")
 
 	ctxlogger := logger.WithContext(ctxcancel, log)
-	err = s.Exec(ctxlogger, spec, state)
+	err = s.exec(ctxlogger, args, spec, state)
 	if err != nil {
 		log.WithError(err).
 			WithField("duration", stage.Stopped-stage.Started).
@@ -291,3 +298,26 @@ func (s *Runner) run(ctx context.Context, stage *drone.Stage, data *client.Conte
 		Debug("updated stage to complete")
 	return nil
 }
+
+// helper function executes the stage. If the pipeline
+// environment was disrupted, for example, because the pipeline
+// pod was evicted, the stage is re-run from scratch in a new
+// pipeline environment, until the retries are exhausted.
+func (s *Runner) exec(ctx context.Context, args CompilerArgs, spec Spec, state *pipeline.State) error {
+	log := logger.FromContext(ctx)
+	err := s.Exec(ctx, spec, state)
+	for errors.Is(err, ErrDisrupted) && state.Retries > 0 {
+		log.WithField("retries", state.Retries).
+			Warnln("pipeline environment disrupted, retrying stage")
+		state.Retry()
+		if err := s.Reporter.ReportStage(noContext, state); err != nil {
+			log.WithError(err).Warnln("cannot report stage retry")
+		}
+		// the stage is compiled again, so the new pipeline
+		// environment is created with new names, and with an
+		// expiration time that starts with the retry.
+		spec = s.Compiler.Compile(ctx, args)
+		err = s.Exec(ctx, spec, state)
+	}
+	return err
+}
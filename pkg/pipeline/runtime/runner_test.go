@@ -3,3 +3,55 @@
 // that can be found in the LICENSE file.
 
 package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
+	"github.com/ozonep/drone/pkg/drone"
+)
+
+// compilerFunc is a helper type that implements the compiler
+// interface with a function.
+type compilerFunc func(context.Context, CompilerArgs) Spec
+
+func (f compilerFunc) Compile(ctx context.Context, args CompilerArgs) Spec {
+	return f(ctx, args)
+}
+
+// This test verifies a stage that is disrupted every time it
+// runs is only retried until the retries are exhausted.
+func TestRunnerExec_Retries(t *testing.T) {
+	var runs, compiles int
+	runner := &Runner{
+		Compiler: compilerFunc(func(context.Context, CompilerArgs) Spec {
+			compiles++
+			return nil
+		}),
+		Reporter: pipeline.NopReporter(),
+		Exec: func(context.Context, Spec, *pipeline.State) error {
+			runs++
+			return ErrDisrupted
+		},
+	}
+	state := &pipeline.State{
+		Build:   &drone.Build{},
+		Stage:   &drone.Stage{},
+		Retries: 2,
+	}
+
+	err := runner.exec(context.Background(), CompilerArgs{}, nil, state)
+	if err != ErrDisrupted {
+		t.Errorf("Want disrupted error once the retries are exhausted, got %v", err)
+	}
+	if got, want := runs, 3; got != want {
+		t.Errorf("Want stage run %d times, got %d", want, got)
+	}
+	if got, want := compiles, 2; got != want {
+		t.Errorf("Want stage compiled %d times, got %d", want, got)
+	}
+	if got, want := state.Retries, 0; got != want {
+		t.Errorf("Want %d retries remaining, got %d", want, got)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 
 	"github.com/ozonep/drone-runner-kube/pkg/manifest"
+	"github.com/ozonep/drone-runner-kube/pkg/pipeline"
 	"github.com/ozonep/drone-runner-kube/pkg/secret"
 	"github.com/ozonep/drone/pkg/drone"
 )
@@ -86,6 +87,26 @@ type (
 		Run(context.Context, Spec, Step, io.Writer) (*State, error)
 	}
 
+	// Debugger is an optional interface that may be implemented
+	// by a pipeline execution engine to support debug mode.
+	Debugger interface {
+		// Debug holds the pipeline environment after the step
+		// fails, so the step can be debugged interactively. It
+		// writes debugging instructions to the step logs, and
+		// blocks until the debug session ends.
+		Debug(context.Context, Spec, Step, io.Writer) error
+	}
+
+	// Waiter is an optional interface that may be implemented
+	// by a pipeline execution engine to support readiness
+	// checks for detached steps.
+	Waiter interface {
+		// WaitReady blocks until the detached step is ready,
+		// and returns an error if the step exits, or is not
+		// ready before the readiness timeout.
+		WaitReady(context.Context, Spec, Step) error
+	}
+
 	// Spec is an interface that must be implemented by all
 	// pipeline specifications.
 	Spec interface {
@@ -129,6 +150,11 @@ type (
 		// and executed in the background.
 		IsDetached() bool
 
+		// IsOnCancel returns true if the step runs when
+		// the pipeline is cancelled, for example, to clean
+		// up external resources.
+		IsOnCancel() bool
+
 		// Clone returns a copy of the Step.
 		Clone() Step
 	}
@@ -144,6 +170,26 @@ type (
 		// OOMKilled reports whether the step has been
 		// killed by the process manager.
 		OOMKilled bool
+
+		// Reason reports the reason the step terminated,
+		// as provided by the process manager.
+		Reason string
+
+		// Message provides a human-readable description
+		// of why the step terminated, if available.
+		Message string
+
+		// Outputs returns the key value pairs written by
+		// the step, which are exposed to dependent steps.
+		Outputs map[string]string
+
+		// Summary returns the markdown summary written by
+		// the step, if available.
+		Summary string
+
+		// Usage returns the resource usage of the step,
+		// if sampled.
+		Usage *pipeline.Usage
 	}
 
 	// Secret is an interface that must be implemented
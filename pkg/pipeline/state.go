@@ -19,12 +19,42 @@ type State struct {
 	Repo   *drone.Repo
 	Stage  *drone.Stage
 	System *drone.System
+
+	// Retries is the number of times the stage can be re-run
+	// from scratch if the pipeline environment is disrupted,
+	// for example, if the pipeline pod is evicted.
+	Retries int
+
+	outputs   map[string]map[string]string
+	summaries map[string]string
+	usages    map[string]*Usage
+	disrupted []string
 }
 
-// Cancel cancels the pipeline.
+// Summary is the markdown summary written by a step.
+type Summary struct {
+	Step     string `json:"step"`
+	Markdown string `json:"markdown"`
+}
+
+// Usage is the resource usage of a step, sampled while the
+// step runs. CPU usage is in millicores, and memory usage is
+// in bytes.
+type Usage struct {
+	Step          string `json:"step"`
+	Samples       int    `json:"samples"`
+	CPUPeak       int64  `json:"cpu_peak"`
+	CPUAverage    int64  `json:"cpu_average"`
+	MemoryPeak    int64  `json:"memory_peak"`
+	MemoryAverage int64  `json:"memory_average"`
+}
+
+// Cancel cancels the pipeline. The running steps are killed,
+// and the pending steps remain pending, so that the steps that
+// run on cancellation can be started. The remaining pending
+// steps are skipped when the pipeline finishes.
 func (s *State) Cancel() {
 	s.Lock()
-	s.skipall()
 	s.killall()
 	s.update()
 	s.Unlock()
@@ -56,6 +86,60 @@ func (s *State) FailAll(err error) {
 	s.Unlock()
 }
 
+// Disrupt fails the named pipeline step because the pipeline
+// environment was disrupted, for example, because the pipeline
+// pod was evicted or preempted.
+func (s *State) Disrupt(name string, err error) {
+	s.Lock()
+	v := s.find(name)
+	s.fail(v, err)
+	s.disrupted = append(s.disrupted, name)
+	s.update()
+	s.Unlock()
+}
+
+// Disrupted returns true if the pipeline environment was
+// disrupted.
+func (s *State) Disrupted() bool {
+	s.Lock()
+	v := len(s.disrupted) != 0
+	s.Unlock()
+	return v
+}
+
+// DisruptedSteps returns the names of the pipeline steps that
+// failed because the pipeline environment was disrupted.
+func (s *State) DisruptedSteps() []string {
+	s.Lock()
+	v := append([]string(nil), s.disrupted...)
+	s.Unlock()
+	return v
+}
+
+// Retry resets the pipeline steps and decrements the remaining
+// retries, so that the stage can be re-run from scratch.
+func (s *State) Retry() {
+	s.Lock()
+	for _, v := range s.Stage.Steps {
+		v.Status = drone.StatusPending
+		v.Started = 0
+		v.Stopped = 0
+		v.ExitCode = 0
+		v.Error = ""
+	}
+	s.Stage.Status = drone.StatusRunning
+	s.Stage.Error = ""
+	s.Stage.ExitCode = 0
+	s.Stage.Stopped = 0
+	s.Build.Status = drone.StatusRunning
+	s.outputs = nil
+	s.summaries = nil
+	s.usages = nil
+	s.disrupted = nil
+	s.Retries--
+	s.Unlock()
+}
+
 // Failed returns true if the pipeline failed.
 func (s *State) Failed() bool {
 	s.Lock()
@@ -122,6 +206,77 @@ func (s *State) Find(name string) *drone.Step {
 	return v
 }
 
+// SetOutputs sets the outputs and the summary written by the
+// named pipeline step.
+func (s *State) SetOutputs(name string, outputs map[string]string, summary string) {
+	s.Lock()
+	if len(outputs) != 0 {
+		if s.outputs == nil {
+			s.outputs = map[string]map[string]string{}
+		}
+		s.outputs[name] = outputs
+	}
+	if summary != "" {
+		if s.summaries == nil {
+			s.summaries = map[string]string{}
+		}
+		s.summaries[name] = summary
+	}
+	s.Unlock()
+}
+
+// SetUsage sets the resource usage of the named pipeline step.
+func (s *State) SetUsage(name string, usage *Usage) {
+	s.Lock()
+	if s.usages == nil {
+		s.usages = map[string]*Usage{}
+	}
+	s.usages[name] = usage
+	s.Unlock()
+}
+
+// Usages returns the resource usage of the pipeline steps, in
+// step order.
+func (s *State) Usages() []*Usage {
+	s.Lock()
+	defer s.Unlock()
+	var usages []*Usage
+	for _, step := range s.Stage.Steps {
+		if v, ok := s.usages[step.Name]; ok {
+			usage := *v
+			usage.Step = step.Name
+			usages = append(usages, &usage)
+		}
+	}
+	return usages
+}
+
+// Outputs returns the outputs written by the named pipeline
+// step.
+func (s *State) Outputs(name string) map[string]string {
+	s.Lock()
+	v := s.outputs[name]
+	s.Unlock()
+	return v
+}
+
+// Summaries returns the markdown summaries written by the
+// pipeline steps, in step order.
+func (s *State) Summaries() []*Summary {
+	s.Lock()
+	defer s.Unlock()
+	var summaries []*Summary
+	for _, step := range s.Stage.Steps {
+		if v, ok := s.summaries[step.Name]; ok {
+			summaries = append(summaries, &Summary{
+				Step:     step.Name,
+				Markdown: v,
+			})
+		}
+	}
+	return summaries
+}
+
 //
 // Helper functions. INTERNAL USE ONLY
 //
@@ -43,6 +43,81 @@ func TestStateKill(t *testing.T) {
 	}
 }
 
+func TestStateCancel(t *testing.T) {
+	running := &drone.Step{Name: "build", Status: drone.StatusRunning}
+	pending := &drone.Step{Name: "cleanup", Status: drone.StatusPending}
+	state := &State{
+		Build: &drone.Build{},
+		Stage: &drone.Stage{
+			Status: drone.StatusRunning,
+			Steps:  []*drone.Step{running, pending},
+		},
+	}
+
+	state.Cancel()
+	if !state.Cancelled() {
+		t.Errorf("Expect pipeline cancelled")
+	}
+	if got, want := running.Status, drone.StatusKilled; got != want {
+		t.Errorf("Want running step status %s, got %s", want, got)
+	}
+
+	// the pending steps can be started after the pipeline
+	// is cancelled, to clean up.
+	state.Start(pending.Name)
+	state.Finish(pending.Name, 0)
+	if got, want := pending.Status, drone.StatusPassing; got != want {
+		t.Errorf("Want cleanup step status %s, got %s", want, got)
+	}
+	state.FinishAll()
+	if got, want := state.Stage.Status, drone.StatusKilled; got != want {
+		t.Errorf("Want stage status %s, got %s", want, got)
+	}
+}
+
+func TestStateRetry(t *testing.T) {
+	step := &drone.Step{Name: "build", Status: drone.StatusRunning, Started: 1}
+	state := &State{
+		Build: &drone.Build{Status: drone.StatusRunning},
+		Stage: &drone.Stage{
+			Status: drone.StatusRunning,
+			Steps:  []*drone.Step{step},
+		},
+		Retries: 1,
+	}
+
+	state.Disrupt(step.Name, errors.New("pod disrupted: Evicted"))
+	if !state.Disrupted() {
+		t.Errorf("Expect pipeline disrupted")
+	}
+	if got := state.DisruptedSteps(); len(got) != 1 || got[0] != step.Name {
+		t.Errorf("Want disrupted steps [%s], got %v", step.Name, got)
+	}
+	if got, want := state.Stage.Status, drone.StatusError; got != want {
+		t.Errorf("Want stage status %s, got %s", want, got)
+	}
+
+	state.Retry()
+	if state.Disrupted() || len(state.DisruptedSteps()) != 0 {
+		t.Errorf("Expect pipeline not disrupted after retry")
+	}
+	if got, want := state.Retries, 0; got != want {
+		t.Errorf("Want %d retries, got %d", want, got)
+	}
+	if got, want := step.Status, drone.StatusPending; got != want {
+		t.Errorf("Want step status %s, got %s", want, got)
+	}
+	if step.Started != 0 || step.Error != "" {
+		t.Errorf("Expect step state reset")
+	}
+	if got, want := state.Stage.Status, drone.StatusRunning; got != want {
+		t.Errorf("Want stage status %s, got %s", want, got)
+	}
+	if got, want := state.Build.Status, drone.StatusRunning; got != want {
+		t.Errorf("Want build status %s, got %s", want, got)
+	}
+}
+
 func TestStateKilled(t *testing.T) {
 	state := &State{}
 	state.Stage = &drone.Stage{Status: drone.StatusError}